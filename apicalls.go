@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// apiCalls counts the requests to the apiserver by verb.
+// The time spent in list, delete and patch requests is summed up separately, all other requests are summed up as other.
+type apiCalls struct {
+	list, get, create, patch, delete, other atomic.Int64
+
+	listTime, patchTime, deleteTime, otherTime atomic.Int64
+}
+
+func (c *apiCalls) String() string {
+	return fmt.Sprintf("list=%d get=%d create=%d patch=%d delete=%d other=%d",
+		c.list.Load(), c.get.Load(), c.create.Load(), c.patch.Load(), c.delete.Load(), c.other.Load())
+}
+
+// Timings formats the time spent in the requests. Concurrent requests are summed up.
+func (c *apiCalls) Timings() string {
+	format := func(d *atomic.Int64) string {
+		return time.Duration(d.Load()).Round(time.Millisecond).String()
+	}
+	return fmt.Sprintf("list=%s patch=%s delete=%s other=%s",
+		format(&c.listTime), format(&c.patchTime), format(&c.deleteTime), format(&c.otherTime))
+}
+
+// timer returns the counter of the time spent in requests like the given one.
+func (c *apiCalls) timer(req *http.Request) *atomic.Int64 {
+	switch {
+	case req.Method == http.MethodPatch:
+		return &c.patchTime
+	case req.Method == http.MethodDelete:
+		return &c.deleteTime
+	case req.Method == http.MethodGet && isList(req):
+		return &c.listTime
+	default:
+		return &c.otherTime
+	}
+}
+
+// count classifies a request by its method and path.
+func (c *apiCalls) count(req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		if isList(req) {
+			c.list.Add(1)
+		} else {
+			c.get.Add(1)
+		}
+	case http.MethodPost:
+		c.create.Add(1)
+	case http.MethodPatch:
+		c.patch.Add(1)
+	case http.MethodDelete:
+		c.delete.Add(1)
+	default:
+		c.other.Add(1)
+	}
+}
+
+// isList returns true if a GET request addresses a collection. This is the case for paths with an odd number
+// of segments after the API version (e.g. namespaces/default/events).
+func isList(req *http.Request) bool {
+	path := strings.Trim(req.URL.Path, "/")
+	var segments []string
+	switch {
+	case strings.HasPrefix(path, "api/"):
+		segments = strings.Split(path, "/")[2:]
+	case strings.HasPrefix(path, "apis/"):
+		segments = strings.Split(path, "/")[3:]
+	}
+	return len(segments)%2 == 1
+}
+
+// countingTransport counts and times every request to the apiserver, including retries.
+type countingTransport struct {
+	calls *apiCalls
+	next  http.RoundTripper
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.calls.count(req)
+	start := time.Now()
+	defer func() {
+		t.calls.timer(req).Add(int64(time.Since(start)))
+	}()
+	return t.next.RoundTrip(req)
+}
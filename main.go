@@ -2,96 +2,611 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
 	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
-	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/flowcontrol"
+
+	"github.com/MartinWeindel/kubectl-filter-output/pkg/cleanup"
 )
 
-type Config struct {
-	Kubeconfig string
-	Duration   time.Duration
-	QPS        float64
-	Burst      int
-	Retries    int
-	DryRun     bool
-	Statistics *Statistics
-}
+// minDuration is the minimum age of events to clean up, unless explicitly overridden.
+const minDuration = 30 * time.Second
+
+// Options are the command line options not passed to the cleanup itself.
+type Options struct {
+	Kubeconfig    string
+	Context       string
+	QPS           float64
+	Burst         int
+	Preflight     bool
+	NoTable       bool
+	AgeQuantiles  bool
+	StartupJitter time.Duration
+	BurstWindow   time.Duration
+	AuditLog      string
+	TextfileOut   string
+	ContentType   string
 
-type Statistics struct {
-	TotalEvents       int
-	DeletedEvents     int
-	NamespacesScanned int
+	// Input is the source of the events to delete with apply-plan or from-stdin. It is empty if the
+	// events are selected by scanning the namespaces.
+	Input string
+
+	AllowShortDuration bool
+	FailOnZero         bool
+	FutureDatedWarn    int
+	MinExpectedDeletes int
 }
 
 func main() {
-	cfg := &Config{
-		Statistics: &Statistics{},
+	os.Exit(run())
+}
+
+// run executes the command and returns the exit code, so that deferred cleanups are done before exiting.
+func run() int {
+	start := time.Now()
+	opts := &Options{}
+	cfg := &cleanup.Config{
+		RunID:     uuid.NewString(),
+		Retention: map[string]time.Duration{},
 	}
-	flag.StringVar(&cfg.Kubeconfig, "kubeconfig", "", "Path to the kubeconfig file. If not specified, KUBECONFIG env variable is used. Use 'in-cluster' for in-cluster configuration.")
+	flag.StringVar(&opts.Kubeconfig, "kubeconfig", "", "Path to the kubeconfig file. If not specified, KUBECONFIG env variable is used, which may contain a list of files to merge. Use 'in-cluster' for in-cluster configuration.")
+	flag.StringVar(&opts.Context, "context", "", "Name of the kubeconfig context to use. If not specified, the current context is used.")
 	flag.DurationVar(&cfg.Duration, "duration", 1*time.Hour, "Duration for the operation")
-	flag.Float64Var(&cfg.QPS, "qps", 200, "Kubernetes client QPS")
-	flag.IntVar(&cfg.Burst, "burst", 50, "Kubernetes client Burst")
+	flag.StringVar(&opts.ContentType, "content-type", "protobuf", "Encoding of the responses of the apiserver: 'protobuf' (less memory and CPU for large lists) or 'json' (e.g. for debugging proxies)")
+	flag.Float64Var(&opts.QPS, "qps", 200, "Kubernetes client QPS")
+	flag.IntVar(&opts.Burst, "burst", 50, "Kubernetes client Burst")
+	flag.DurationVar(&opts.BurstWindow, "kube-api-burst-window", 0, "If set, the QPS is halved on a throttling response (429) of the apiserver, at most once per window, and raised again by a tenth after each window without throttling. The rate changes are reported in the summary.")
 	flag.IntVar(&cfg.Retries, "retries", 2, "Number of retries for Kubernetes client operations")
+	retryHTTPStatus := flag.String("retry-http-status", joinInts(cleanup.DefaultRetryHTTPStatus), "Comma-separated list of HTTP status codes of API errors which are retried. Errors without status, like network errors, are always retried.")
+	flag.IntVar(&cfg.MaxConcurrentDeletesPerNamespace, "max-concurrent-deletes-per-namespace", 1, "Number of events deleted in parallel within a namespace")
+	flag.IntVar(&cfg.MaxConcurrentDeletes, "max-concurrent-deletes", 10, "Maximum number of deletes in flight over all namespaces")
+	flag.IntVar(&cfg.MaxTotalEvents, "max-total-events", 0, "If set, no further namespaces are scanned once this many events have been scanned. The exit code of a truncated run is 3.")
+	concurrency := flag.String("concurrency", "1", "Number of namespaces cleaned up concurrently, or 'auto' to derive it from the number of namespaces and qps")
+	flag.DurationVar(&cfg.NamespaceTimeout, "delete-timeout-per-namespace", 30*time.Minute, "Maximum duration of the cleanup of a single namespace. Namespaces exceeding it are abandoned and reported as timed out. If 0, it is unlimited.")
+	flag.IntVar(&cfg.MaxNamespaceErrors, "max-namespace-errors", 0, "Number of failed deletes skipped in a namespace before its cleanup is aborted. Namespaces aborted due to admission webhook denials are reported as blocked.")
+	flag.BoolVar(&cfg.StrictVersion, "strict-version", false, "If true, events are only deleted in the resource version they were listed with. Events which have changed since are skipped.")
+	flag.BoolVar(&cfg.RetryFailedAtEnd, "retry-failed-at-end", false, "If true, failed deletes skipped due to max-namespace-errors are retried once after all events of the namespace have been processed. Requires a positive max-namespace-errors")
+	flag.Int64Var(&cfg.RetryBudget, "retry-budget", 0, "Maximum total number of retries for the whole run. If 0, the number of retries is only limited per operation.")
+	flag.BoolVar(&opts.AllowShortDuration, "allow-short-duration", false, "If true, durations below 30 seconds are allowed, down to 0 for all events")
 	flag.BoolVar(&cfg.DryRun, "dry-run", false, "If true, no changes will be made")
+	flag.BoolVar(&cfg.CountOnly, "count-only", false, "If true, events are only counted and nothing is deleted")
+	flag.BoolVar(&cfg.ByReason, "by-reason", false, "If true, expired events are also counted by reason. Requires count-only.")
+	templateFile := flag.String("template-file", "", "Path of a Go text/template rendered for each selected event in dry-run mode. It receives .Event and .Age.")
+	whatIf := flag.String("what-if", "", "Comma-separated list of alternative durations (e.g. 1h,6h,24h,7d) for which the expired events are counted in the same scan. Requires dry-run or count-only.")
+	flag.BoolVar(&opts.NoTable, "no-table", false, "If true, the summary is printed as a plain list instead of tables")
+	flag.BoolVar(&opts.AgeQuantiles, "age-quantiles", false, "If true, quantiles of the age of the deleted events are printed in the summary")
+	flag.BoolVar(&cfg.EstimateSize, "estimate-size", false, "If true, the storage size of the affected events is estimated and printed in the summary")
+	flag.BoolVar(&opts.FailOnZero, "fail-on-zero", false, "If true, the exit code is non-zero if no namespaces matched the filters, if no events were read with apply-plan or from-stdin, or if no events were deleted although events exist (not in dry-run or count-only mode)")
+	flag.IntVar(&opts.MinExpectedDeletes, "min-expected-deletions", 0, "If set, the exit code is non-zero if fewer events were deleted (not in dry-run or count-only mode)")
+	flag.IntVar(&opts.FutureDatedWarn, "future-dated-warn-threshold", 10, "A clock skew warning is printed if more events than this number have a creation timestamp in the future")
+	flag.BoolVar(&cfg.VerifyPermissions, "verify-permissions", false, "If true, a few selected events of each namespace are deleted with server-side dry run in dry-run mode to detect missing permissions and denying admission webhooks")
+	flag.BoolVar(&opts.Preflight, "preflight", false, "If true, the needed permissions are checked before starting the cleanup")
+	flag.BoolVar(&cfg.RespectNamespaceAnnotations, "respect-namespace-annotations", false, "If true, the annotation "+cleanup.RetentionAnnotation+" of a namespace overrides duration and since for its events")
+	flag.DurationVar(&cfg.MinRetention, "min-retention", time.Hour, "Minimum retention accepted from namespace annotations")
+	flag.Var((*stringsFlag)(&cfg.FieldSelectors), "or-selector", "Field selector of events to clean up, e.g. type=Warning. May be repeated, events matching any of them are cleaned up. Each selector costs its own list requests.")
+	flag.Var(retentionFlag(cfg.Retention), "retention", "Retention of events with a specific reason as reason=duration, overriding duration and since. In namespaces with a retention annotation, the longer retention applies. May be repeated.")
+	since := flag.String("since", "", "Absolute cutoff time in RFC3339 format. If specified, events older than this time are cleaned up instead of using duration.")
+	namespaces := flag.String("namespace", "", "Comma-separated list of namespaces to clean up. If not specified, all namespaces are cleaned up.")
+	flag.StringVar(&cfg.NamespaceLabelSelector, "namespace-label-selector", "", "Label selector to filter the namespaces to clean up")
+	flag.StringVar(&cfg.ResourceVersion, "resource-version", "", "Resource version used for listing events. If not specified, the most recent state is read.")
+	flag.StringVar(&cfg.ResourceVersionMatch, "resource-version-match", "", "How the resource version is applied when listing events: 'Exact' or 'NotOlderThan'")
+	flag.DurationVar(&opts.StartupJitter, "startup-jitter", 0, "Maximum random delay before starting the cleanup")
+	flag.IntVar(&cfg.WarnEventCount, "warn-namespace-event-count", 0, "If set, a warning is logged for namespaces with more events than this number")
+	flag.BoolVar(&cfg.SkipOverLimit, "skip-over-limit", false, "If true, no events are deleted in namespaces exceeding warn-namespace-event-count")
+	flag.BoolVar(&cfg.SkipNewNamespaces, "skip-namespaces-newer-than", false, "If true, namespaces created after the cutoff time are skipped, as they cannot contain expired events")
+	flag.IntVar(&cfg.SampleNamespaces, "sample", 0, "If set, only a random sample of this many namespaces is scanned and the counts are extrapolated to all namespaces. Requires dry-run or count-only.")
+	flag.StringVar(&cfg.NamespaceOrder, "namespace-order", cleanup.NamespaceOrderName, "Order in which the namespaces are processed: 'name', 'event-count' (most events first) or 'api' (as listed by the apiserver)")
+	flag.Int64Var(&cfg.PageSize, "page-size", 0, "Number of events listed per request. If 0, all events of a namespace are listed at once. Otherwise events are deleted page by page if possible, which bounds the memory usage.")
+	flag.DurationVar(&cfg.BucketDuration, "bucket-duration", 0, "If set, events are deleted in successive age buckets of this size (oldest bucket first). Not supported in dry-run or count-only mode and with apply-plan or from-stdin.")
+	flag.StringVar(&cfg.AgeBasis, "age-basis", cleanup.AgeBasisEffective, "Timestamp used to determine the age of an event: 'creation', 'last' or 'effective' (latest of all timestamps)")
+	filterCEL := flag.String("filter-cel", "", "CEL expression selecting events for deletion. It has access to the map 'event' with the keys reason, type, message, count, ageSeconds and involvedObject.")
+	celMode := flag.String("cel-mode", cleanup.CELModeAnd, "How filter-cel is combined with the age check: 'and' or 'or'")
+	flag.IntVar(&cfg.Explain, "explain", 0, "If set, the rule deciding to select or retain an event is logged for up to this many events. Requires dry-run.")
+	flag.BoolVar(&cfg.IncludeSelf, "include-self", false, "If true, events reported by cleanup-events itself are cleaned up, too")
+	includeReasons := flag.String("include-reasons", "", "Comma separated list of reasons. If set, only events with one of these reasons are cleaned up.")
+	includeReasonFile := flag.String("include-reason-file", "", "Path of a file with reasons to include, one per line. Merged with include-reasons.")
+	excludeReasons := flag.String("exclude-reasons", "", "Comma separated list of reasons of events which are always retained")
+	excludeReasonFile := flag.String("exclude-reason-file", "", "Path of a file with reasons to exclude, one per line. Merged with exclude-reasons.")
+	flag.BoolVar(&cfg.RequireMatchingInvolvedNamespace, "require-matching-involved-namespace", false, "If true, only events whose involved object is in the namespace of the event are cleaned up")
+	involvedNameRegex := flag.String("involved-name-regex", "", "If set, only events whose involved object name matches this regular expression are cleaned up")
+	excludeInvolvedNameRegex := flag.String("exclude-involved-name-regex", "", "If set, events whose involved object name matches this regular expression are retained")
+	sourceComponentRegex := flag.String("source-component-regex", "", "If set, only events whose reporting component matches this regular expression are cleaned up")
+	excludeSourceComponentRegex := flag.String("exclude-source-component-regex", "", "If set, events whose reporting component matches this regular expression are retained")
+	flag.BoolVar(&cfg.MarkOnly, "mark-only", false, "If true, expired events are annotated with "+cleanup.ExpiredAnnotation+"=true instead of being deleted")
+	flag.StringVar(&cfg.TTLLabel, "ttl-label", "", "If set as key=value, expired events are labeled with it instead of being deleted, so that an external TTL controller can remove them (implies mark-only)")
+	flag.StringVar(&cfg.FieldManager, "field-manager", cleanup.ComponentName, "Field manager of the patches in mark-only mode")
+	flag.BoolVar(&cfg.DeleteAnnotated, "delete-annotated", false, "If true, only events annotated with "+cleanup.ExpiredAnnotation+"=true by a previous mark-only run are deleted")
+	approvalWebhook := flag.String("approval-webhook", "", "URL of a webhook approving the events to delete per namespace. Only the event names returned in its 'approved' list are deleted.")
+	approvalFailMode := flag.String("approval-fail-mode", cleanup.ApprovalFailClosed, "What to do if the approval webhook fails: 'closed' skips the namespace, 'open' deletes all selected events")
+	planPath := flag.String("plan", "", "Path of a file to write the events selected for deletion to, without deleting them (implies dry-run)")
+	probe := flag.Bool("probe", false, "If true, only the first page of events of each namespace is checked for expired events. The exit code is 0 if expired events were found and 1 otherwise.")
+	applyPlan := flag.String("apply-plan", "", "Path of a plan file whose events are deleted, unless they have changed since. No other events are selected.")
+	allowedWindow := flag.String("allowed-window", "", "If set, events are only deleted or marked within this daily time window of the form HH:MM-HH:MM (e.g. 22:00-05:00). Outside of it, the run exits without changes, and a run still active at its end is interrupted.")
+	windowTimezone := flag.String("window-timezone", "Local", "Timezone of allowed-window, e.g. 'UTC' or 'Europe/Berlin'")
+	fromStdin := flag.Bool("from-stdin", false, "If true, the events listed as namespace/name lines on stdin are deleted. No other events are selected.")
+	flag.StringVar(&opts.TextfileOut, "textfile-out", "", "Path of a file to write the statistics of the run to in the Prometheus text format, e.g. for the textfile collector of the node exporter")
+	flag.StringVar(&opts.AuditLog, "audit-log", "", "Path of a file to append an audit record for each deleted event to")
+	maxCountToDelete := flag.Int("max-count-to-delete", 0, "If set, events with a higher count of occurrences are retained regardless of their age")
+	flag.DurationVar(&cfg.ProtectRecentPerReason, "protect-recent-per-reason", 0, "If set, the events of each reason within this window before the latest event of the reason in a namespace are retained regardless of their age")
+	flag.DurationVar(&cfg.SkipIfObjectModifiedWithin, "skip-if-object-modified-within", 0, "If set, events whose involved object has been modified within this duration are retained. Costs a Get request per involved object.")
+	flag.DurationVar(&cfg.MinSeriesGap, "min-series-gap", 0, "If set, events of a series last observed within this duration are retained regardless of their age")
+	flag.DurationVar(&cfg.MinDeleteInterval, "min-delete-interval", 0, "If set, consecutive deletes are spaced by at least this interval, independent of qps and burst")
+	flag.DurationVar(&cfg.BucketPause, "bucket-pause", 5*time.Second, "Pause between age buckets if bucket-duration is set")
 	flag.Parse()
 
-	if cfg.Duration < 30*time.Second {
-		panic("duration must be greater or equal than 30 seconds")
+	for _, ns := range strings.Split(*namespaces, ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			cfg.Namespaces = append(cfg.Namespaces, ns)
+		}
+	}
+
+	if *since != "" {
+		durationSet := false
+		flag.Visit(func(f *flag.Flag) {
+			durationSet = durationSet || f.Name == "duration"
+		})
+		if durationSet {
+			panic("only one of duration and since may be specified")
+		}
+		var err error
+		cfg.Since, err = time.Parse(time.RFC3339, *since)
+		if err != nil {
+			panic(fmt.Sprintf("invalid since: %s", err))
+		}
+		if time.Since(cfg.Since) < minDuration {
+			if !opts.AllowShortDuration {
+				panic("since must be at least 30 seconds in the past (use allow-short-duration to override)")
+			}
+			fmt.Printf("Warning: since is less than 30 seconds in the past\n")
+		}
+	} else if cfg.Duration < minDuration {
+		if !opts.AllowShortDuration {
+			panic("duration must be greater or equal than 30 seconds (use allow-short-duration to override)")
+		}
+		if cfg.Duration < 0 {
+			panic("duration must not be negative")
+		}
+		fmt.Printf("Warning: duration %s is less than 30 seconds\n", cfg.Duration)
+	}
+	if *concurrency == "auto" {
+		cfg.AutoConcurrencyQPS = opts.QPS
+	} else if n, err := strconv.Atoi(*concurrency); err != nil {
+		panic(fmt.Sprintf("invalid concurrency: %s", err))
+	} else {
+		cfg.NamespaceConcurrency = n
+	}
+	var err error
+	if cfg.IncludeReasons, err = readReasons(*includeReasons, *includeReasonFile); err != nil {
+		panic(fmt.Sprintf("invalid include-reason-file: %s", err))
+	}
+	if cfg.ExcludeReasons, err = readReasons(*excludeReasons, *excludeReasonFile); err != nil {
+		panic(fmt.Sprintf("invalid exclude-reason-file: %s", err))
+	}
+	for _, value := range strings.Split(*retryHTTPStatus, ",") {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		code, err := strconv.Atoi(value)
+		if err != nil {
+			panic(fmt.Sprintf("invalid retry-http-status: %s", err))
+		}
+		cfg.RetryHTTPStatus = append(cfg.RetryHTTPStatus, code)
+	}
+	if cfg.RetryHTTPStatus == nil {
+		// an empty list retries no API errors
+		cfg.RetryHTTPStatus = []int{}
+	}
+	if *whatIf != "" {
+		for _, value := range strings.Split(*whatIf, ",") {
+			d, err := parseDays(strings.TrimSpace(value))
+			if err != nil {
+				panic(fmt.Sprintf("invalid what-if: %s", err))
+			}
+			cfg.WhatIf = append(cfg.WhatIf, d)
+		}
+	}
+	for reason, d := range cfg.Retention {
+		if d < minDuration && !opts.AllowShortDuration {
+			panic(fmt.Sprintf("retention of reason %s must be greater or equal than 30 seconds (use allow-short-duration to override)", reason))
+		}
+	}
+	if *involvedNameRegex != "" {
+		var err error
+		if cfg.InvolvedNameRegex, err = regexp.Compile(*involvedNameRegex); err != nil {
+			panic(fmt.Sprintf("invalid involved-name-regex: %s", err))
+		}
+	}
+	if *excludeInvolvedNameRegex != "" {
+		var err error
+		if cfg.ExcludeInvolvedNameRegex, err = regexp.Compile(*excludeInvolvedNameRegex); err != nil {
+			panic(fmt.Sprintf("invalid exclude-involved-name-regex: %s", err))
+		}
+	}
+	if *sourceComponentRegex != "" {
+		var err error
+		if cfg.SourceComponentRegex, err = regexp.Compile(*sourceComponentRegex); err != nil {
+			panic(fmt.Sprintf("invalid source-component-regex: %s", err))
+		}
+	}
+	if *excludeSourceComponentRegex != "" {
+		var err error
+		if cfg.ExcludeSourceComponentRegex, err = regexp.Compile(*excludeSourceComponentRegex); err != nil {
+			panic(fmt.Sprintf("invalid exclude-source-component-regex: %s", err))
+		}
+	}
+	if *approvalWebhook != "" {
+		cfg.ApprovalWebhook = &cleanup.ApprovalWebhook{
+			URL:      *approvalWebhook,
+			FailMode: *approvalFailMode,
+			Client:   &http.Client{Timeout: 30 * time.Second},
+		}
 	}
-	fmt.Printf("Starting cleanup of events older than %s\n", cfg.Duration.String())
-	if cfg.DryRun {
+	if *maxCountToDelete > math.MaxInt32 {
+		panic("max-count-to-delete is too large")
+	}
+	cfg.MaxCountToDelete = int32(*maxCountToDelete)
+	if cfg.TTLLabel != "" {
+		cfg.MarkOnly = true
+	}
+	if *applyPlan != "" && *fromStdin {
+		panic("only one of apply-plan and from-stdin may be specified")
+	}
+	if *applyPlan != "" && (cfg.MarkOnly || cfg.CountOnly) {
+		panic("apply-plan cannot be combined with mark-only, ttl-label or count-only")
+	}
+	if *fromStdin && (cfg.MarkOnly || cfg.CountOnly) {
+		panic("from-stdin cannot be combined with mark-only, ttl-label or count-only")
+	}
+	if (*applyPlan != "" || *fromStdin) && cfg.BucketDuration > 0 {
+		panic("bucket-duration cannot be combined with apply-plan or from-stdin")
+	}
+	if *planPath != "" {
+		if *applyPlan != "" || *fromStdin || cfg.MarkOnly || cfg.CountOnly {
+			panic("plan cannot be combined with apply-plan, from-stdin, mark-only or count-only")
+		}
+		cfg.DryRun = true
+	}
+	var planEntries []cleanup.PlanEntry
+	if *applyPlan != "" {
+		opts.Input = *applyPlan
+		var err error
+		if planEntries, err = cleanup.ReadPlan(*applyPlan); err != nil {
+			panic(err.Error())
+		}
+	}
+	if *fromStdin {
+		opts.Input = "stdin"
+		var err error
+		if planEntries, err = cleanup.ReadEventNames(os.Stdin); err != nil {
+			panic(err.Error())
+		}
+	}
+	if *templateFile != "" {
+		var err error
+		if cfg.DryRunTemplate, err = cleanup.ParseTemplateFile(*templateFile); err != nil {
+			panic(fmt.Sprintf("invalid template-file: %s", err))
+		}
+	}
+	if *filterCEL != "" {
+		var err error
+		if cfg.CELFilter, err = cleanup.NewCELFilter(*filterCEL, *celMode); err != nil {
+			panic(fmt.Sprintf("invalid filter-cel: %s", err))
+		}
+	}
+	var window *timeWindow
+	if *allowedWindow != "" {
+		location, err := time.LoadLocation(*windowTimezone)
+		if err != nil {
+			panic(fmt.Sprintf("invalid window-timezone: %s", err))
+		}
+		if window, err = parseTimeWindow(*allowedWindow, location); err != nil {
+			panic(fmt.Sprintf("invalid allowed-window: %s", err))
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		panic(err.Error())
+	}
+	if opts.ContentType != "protobuf" && opts.ContentType != "json" {
+		panic(fmt.Sprintf("invalid content-type: %s", opts.ContentType))
+	}
+	if opts.QPS <= 0 || opts.Burst < 1 {
+		panic("qps must be positive and burst must be at least 1")
+	}
+	if opts.MinExpectedDeletes < 0 {
+		panic("min-expected-deletions must not be negative")
+	}
+	if opts.BurstWindow < 0 {
+		panic("kube-api-burst-window must not be negative")
+	}
+	if opts.StartupJitter < 0 {
+		panic("startup-jitter must not be negative")
+	}
+	olderThan := cfg.Duration.String()
+	if !cfg.Since.IsZero() {
+		olderThan = cfg.Since.Format(time.RFC3339)
+	}
+	switch {
+	case *applyPlan != "":
+		fmt.Printf("Applying plan %s with %d events\n", *applyPlan, len(planEntries))
+	case *fromStdin:
+		fmt.Printf("Deleting %d events read from stdin\n", len(planEntries))
+	case cfg.CountOnly:
+		fmt.Printf("Counting events older than %s\n", olderThan)
+	default:
+		fmt.Printf("Starting cleanup of events older than %s\n", olderThan)
+	}
+	if cfg.DryRun && !cfg.CountOnly {
 		fmt.Printf("Dry run mode enabled, no events will be deleted.\n")
 	}
 
-	clientset, err := createClientSet(cfg)
+	calls := &apiCalls{}
+	var adaptive *adaptiveRate
+	if opts.BurstWindow > 0 {
+		adaptive = newAdaptiveRate(rate.Limit(opts.QPS), opts.BurstWindow)
+	}
+	clientset, err := createClientSet(opts, calls, adaptive, cfg.RunID)
 	if err != nil {
 		panic(err.Error())
 	}
 
-	ctx := context.Background()
-	if err := cleanupAllEvents(ctx, clientset, cfg); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if opts.StartupJitter > 0 {
+		delay := rand.N(opts.StartupJitter)
+		fmt.Printf("Delaying start by %s\n", delay)
+		select {
+		case <-ctx.Done():
+			fmt.Printf("Cancelled during startup delay\n")
+			return 0
+		case <-time.After(delay):
+		}
+	}
+	if window != nil && !cfg.DryRun && !cfg.CountOnly && !*probe {
+		// checked after the startup delay, which may have moved the start out of the window
+		now := time.Now()
+		if !window.contains(now) {
+			fmt.Printf("Outside of the allowed window %s, no events are deleted.\n", window)
+			return 0
+		}
+		// the run is interrupted at the end of the window
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, window.endAfter(now))
+		defer cancel()
+	}
+	if opts.AuditLog != "" {
+		actor, err := cleanup.WhoAmI(ctx, clientset)
+		if err != nil {
+			fmt.Printf("Cannot determine the user for the audit log: %s\n", err)
+		}
+		if cfg.AuditLog, err = cleanup.OpenAuditLog(opts.AuditLog, cfg.RunID, actor); err != nil {
+			panic(err.Error())
+		}
+		fmt.Printf("Writing audit log for run %s to %s\n", cfg.RunID, opts.AuditLog)
+		defer func() {
+			if err := cfg.AuditLog.Close(); err != nil {
+				fmt.Printf("%s\n", err)
+			}
+		}()
+	}
+
+	if *planPath != "" {
+		if cfg.Plan, err = cleanup.CreatePlan(*planPath); err != nil {
+			panic(err.Error())
+		}
+		fmt.Printf("Writing plan to %s\n", *planPath)
+		defer func() {
+			if err := cfg.Plan.Close(); err != nil {
+				fmt.Printf("%s\n", err)
+			}
+		}()
+	}
+
+	cleaner := cleanup.NewCleaner(clientset, cfg)
+	if opts.Preflight {
+		var err error
+		if *applyPlan != "" || *fromStdin {
+			err = cleaner.PreflightPlan(ctx, planEntries)
+		} else {
+			err = cleaner.Preflight(ctx)
+		}
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+	if *probe {
+		found, err := cleaner.Probe(ctx)
+		if err != nil {
+			panic(err.Error())
+		}
+		if !found {
+			fmt.Printf("No expired events found\n")
+			return 1
+		}
+		return 0
+	}
+	var stats *cleanup.Statistics
+	if *applyPlan != "" || *fromStdin {
+		stats, err = cleaner.ApplyPlan(ctx, planEntries)
+	} else {
+		stats, err = cleaner.Run(ctx)
+	}
+	if err != nil && ctx.Err() == nil {
 		panic(err.Error())
 	}
+	printSummary(cfg, stats, opts, calls, adaptive, start)
+	if opts.TextfileOut != "" {
+		if err := writeTextfile(opts.TextfileOut, cfg, stats, calls, adaptive, start); err != nil {
+			fmt.Printf("%s\n", err)
+			return 1
+		}
+	}
+	if err != nil {
+		// the run has been interrupted, the summary covers the namespaces processed so far
+		if errors.Is(err, context.DeadlineExceeded) && window != nil {
+			fmt.Printf("Interrupted: the allowed window %s has ended\n", window)
+		} else {
+			fmt.Printf("Interrupted: %s\n", err)
+		}
+		return 1
+	}
+	if err := checkExpectedDeletions(cfg, stats, opts); err != nil {
+		fmt.Printf("%s\n", err)
+		return 1
+	}
+	if len(stats.TruncatedNamespaces) > 0 {
+		// a distinct exit code, so that a truncated run is neither taken as a complete one nor as a failed one
+		// (1) or a configuration error (2, the exit code of a panic)
+		return 3
+	}
+	return 0
 }
 
-func cleanupAllEvents(ctx context.Context, clientset *kubernetes.Clientset, cfg *Config) error {
-	namespaceList, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+// checkExpectedDeletions detects runs which deleted fewer events than expected, which usually
+// indicates a misconfigured filter. Except for the checks that any namespace matched or that
+// any event was read with apply-plan or from-stdin, dry runs and count-only runs are never checked.
+func checkExpectedDeletions(cfg *cleanup.Config, stats *cleanup.Statistics, opts *Options) error {
+	if opts.FailOnZero && opts.Input != "" && stats.TotalEvents == 0 {
+		return fmt.Errorf("no events were read from %s", opts.Input)
+	}
+	if opts.FailOnZero && opts.Input == "" && stats.NamespacesScanned == 0 && stats.TotalEvents == 0 {
+		return fmt.Errorf("no namespaces matched the filters")
+	}
+	if cfg.DryRun || cfg.CountOnly {
+		return nil
+	}
+	affected := stats.DeletedEvents
+	if cfg.MarkOnly {
+		affected = stats.MarkedEvents
+	}
+	if opts.FailOnZero && affected == 0 && stats.TotalEvents > 0 {
+		return fmt.Errorf("no events were affected out of %d events, check the filters", stats.TotalEvents)
+	}
+	if affected < opts.MinExpectedDeletes {
+		return fmt.Errorf("only %d events were affected, expected at least %d", affected, opts.MinExpectedDeletes)
+	}
+	return nil
+}
+
+// joinInts formats a list of numbers as comma-separated string.
+func joinInts(values []int) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = strconv.Itoa(v)
+	}
+	return strings.Join(s, ",")
+}
+
+// readReasons returns the set of the comma separated reasons merged with the reasons in the file,
+// one per line. Empty lines and lines starting with '#' are ignored. The file must not be empty.
+func readReasons(list, path string) (map[string]bool, error) {
+	reasons := map[string]bool{}
+	for _, reason := range strings.Split(list, ",") {
+		if reason = strings.TrimSpace(reason); reason != "" {
+			reasons[reason] = true
+		}
+	}
+	if path == "" {
+		return reasons, nil
+	}
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("error listing namespaces: %w", err)
+		return nil, err
+	}
+	found := false
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			reasons[line] = true
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%s contains no reasons", path)
 	}
-	for _, ns := range namespaceList.Items {
-		fmt.Printf("Namespace: %s\n", ns.Name)
-		if err := cleanupEvents(ctx, clientset, ns.Name, cfg); err != nil {
-			fmt.Printf("error cleaning up events in namespace %s: %w", ns.Name, err)
+	return reasons, nil
+}
+
+// parseDays parses a duration, additionally accepting a number of days like "7d".
+func parseDays(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number of days %q", value)
 		}
-		cfg.Statistics.NamespacesScanned++
+		return time.Duration(n) * 24 * time.Hour, nil
 	}
-	mode := "Deleted"
-	msg := "Cleanup completed successfully.\n"
-	if cfg.DryRun {
-		mode = "To be deleted"
-		msg = "Dry run completed successfully.\n"
+	return time.ParseDuration(value)
+}
+
+// stringsFlag collects the values of a repeatable flag.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	if f == nil {
+		return ""
 	}
-	fmt.Printf(msg)
-	fmt.Printf("Statistics:\n")
-	fmt.Printf("  Namespaces scanned: %d\n", cfg.Statistics.NamespacesScanned)
-	fmt.Printf("  Total events: %d\n", cfg.Statistics.TotalEvents)
-	fmt.Printf("  %s events: %d\n", mode, cfg.Statistics.DeletedEvents)
-	fmt.Printf("  Retained events: %d\n", cfg.Statistics.TotalEvents-cfg.Statistics.DeletedEvents)
+	return strings.Join(*f, " ")
+}
 
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
 	return nil
 }
 
-func createClientSet(cfg *Config) (*kubernetes.Clientset, error) {
-	kubeconfig := cfg.Kubeconfig
+// retentionFlag collects the reason=duration pairs of the repeatable retention flag.
+type retentionFlag map[string]time.Duration
+
+func (f retentionFlag) String() string {
+	var pairs []string
+	for reason, d := range f {
+		pairs = append(pairs, reason+"="+d.String())
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f retentionFlag) Set(value string) error {
+	reason, duration, ok := strings.Cut(value, "=")
+	if !ok || reason == "" {
+		return fmt.Errorf("expected reason=duration, got %q", value)
+	}
+	d, err := time.ParseDuration(duration)
+	if err != nil {
+		return err
+	}
+	f[reason] = d
+	return nil
+}
+
+// kubeconfigLoader loads the kubeconfig given by the options or the KUBECONFIG environment variable.
+// The default loading rules merge the files of a KUBECONFIG path list like kubectl.
+func kubeconfigLoader(opts *Options) clientcmd.ClientConfig {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = opts.Kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: opts.Context}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+}
+
+func createClientSet(opts *Options, calls *apiCalls, adaptive *adaptiveRate, runID string) (*kubernetes.Clientset, error) {
+	kubeconfig := opts.Kubeconfig
 	if kubeconfig == "" {
 		kubeconfig = os.Getenv("KUBECONFIG")
 	}
@@ -106,76 +621,33 @@ func createClientSet(cfg *Config) (*kubernetes.Clientset, error) {
 		config, err = rest.InClusterConfig()
 	} else {
 		fmt.Printf("Using kubeconfig: %s\n", kubeconfig)
-		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+		config, err = kubeconfigLoader(opts).ClientConfig()
 	}
 	if err != nil {
 		panic(err.Error())
 	}
 
-	// Increase QPS and Burst to handle large number of requests
-	config.QPS = float32(cfg.QPS)
-	config.Burst = cfg.Burst
-
-	return kubernetes.NewForConfig(config)
-}
-
-func cleanupEvents(ctx context.Context, clientset *kubernetes.Clientset, namespace string, cfg *Config) error {
-	eventsClient := clientset.CoreV1().Events(namespace)
-	var eventsList *corev1.EventList
-	if err := opWithRetries(func() error {
-		var listErr error
-		eventsList, listErr = eventsClient.List(ctx, metav1.ListOptions{})
-		return listErr
-	}, cfg.Retries); err != nil {
-		return fmt.Errorf("error listing events: %w", err)
+	if opts.ContentType == "protobuf" {
+		// the built-in types are transferred as protobuf, which is smaller and faster to decode than JSON.
+		// Custom resources are only served as JSON, which stays acceptable.
+		config.ContentType = runtime.ContentTypeProtobuf
+		config.AcceptContentTypes = runtime.ContentTypeProtobuf + "," + runtime.ContentTypeJSON
 	}
 
-	cutoffTime := time.Now().Add(-cfg.Duration)
-	var toDelete []string
-	for _, event := range eventsList.Items {
-		if event.CreationTimestamp.Time.Before(cutoffTime) && event.LastTimestamp.Time.IsZero() {
-			toDelete = append(toDelete, event.Name)
-		} else if event.LastTimestamp.Time.Before(cutoffTime) {
-			toDelete = append(toDelete, event.Name)
-		}
-	}
+	// the run ID in the user agent lets the audit log of the apiserver attribute the requests to a run
+	config.UserAgent = cleanup.ComponentName + " run/" + runID
 
-	cfg.Statistics.TotalEvents += len(eventsList.Items)
-	cfg.Statistics.DeletedEvents += len(toDelete)
-	if len(toDelete) == 0 {
-		fmt.Printf("No events to delete in namespace %s (total: %d events)\n", namespace, len(eventsList.Items))
-		return nil
-	}
-	fmt.Printf("Found %d events to delete in namespace %s (total: %d events)\n", len(toDelete), namespace, len(eventsList.Items))
-	if cfg.DryRun {
-		return nil
-	}
-	for i, eventName := range toDelete {
-		if err := opWithRetries(func() error {
-			err := eventsClient.Delete(ctx, eventName, metav1.DeleteOptions{})
-			if err != nil && !errors.IsNotFound(err) {
-				return err
-			}
-			return nil
-		}, cfg.Retries); err != nil {
-			return fmt.Errorf("error deleting event %s: %w", eventName, err)
-		}
-		if (i+1)%500 == 0 {
-			fmt.Printf("  Deleted %d/%d events in namespace %s\n", i+1, len(toDelete), namespace)
-		}
-	}
-	fmt.Printf("Deleted %d events in namespace %s\n", len(toDelete), namespace)
-	return nil
-}
+	// All requests pass a single shared limiter, which gives a predictable ceiling for the total QPS.
+	// The limiter of client-go is disabled, as it would only add its own burst behaviour.
+	limiter := rate.NewLimiter(rate.Limit(opts.QPS), opts.Burst)
+	config.RateLimiter = flowcontrol.NewFakeAlwaysRateLimiter()
+	// the counting transport is wrapped first, so that waiting for the limiter is not timed
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &countingTransport{calls: calls, next: rt}
+	})
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &rateLimitedTransport{limiter: limiter, adaptive: adaptive, next: rt}
+	})
 
-func opWithRetries(op func() error, retries int) error {
-	var err error
-	for i := 0; i <= retries; i++ {
-		err = op()
-		if err == nil {
-			return nil
-		}
-		time.Sleep(time.Duration(i+1) * 50 * time.Millisecond)
-	}
-	return err
+	return kubernetes.NewForConfig(config)
 }
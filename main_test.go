@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MartinWeindel/kubectl-filter-output/pkg/cleanup"
+)
+
+const kubeconfigTemplate = `apiVersion: v1
+kind: Config
+clusters:
+- name: NAME
+  cluster:
+    server: https://NAME.example.com
+users:
+- name: NAME
+  user:
+    token: NAME-token
+contexts:
+- name: NAME
+  context:
+    cluster: NAME
+    user: NAME
+`
+
+// writeKubeconfig writes a kubeconfig with a cluster, user and context of the name.
+// If current is set, its context is the current one.
+func writeKubeconfig(t *testing.T, name string, current bool) string {
+	t.Helper()
+	content := strings.ReplaceAll(kubeconfigTemplate, "NAME", name)
+	if current {
+		content += "current-context: " + name + "\n"
+	}
+	path := filepath.Join(t.TempDir(), name+".yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestKubeconfigLoaderMergesPathList(t *testing.T) {
+	first := writeKubeconfig(t, "first", false)
+	second := writeKubeconfig(t, "second", true)
+	t.Setenv("KUBECONFIG", first+string(os.PathListSeparator)+second)
+
+	tests := []struct {
+		name     string
+		opts     Options
+		wantHost string
+	}{
+		{"current context of the second file", Options{}, "https://second.example.com"},
+		{"context of the first file", Options{Context: "first"}, "https://first.example.com"},
+		{"explicit kubeconfig", Options{Kubeconfig: first, Context: "first"}, "https://first.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := kubeconfigLoader(&tt.opts).ClientConfig()
+			if err != nil {
+				t.Fatalf("ClientConfig: %s", err)
+			}
+			if config.Host != tt.wantHost {
+				t.Errorf("host = %s, want %s", config.Host, tt.wantHost)
+			}
+		})
+	}
+
+	// the explicit kubeconfig is not merged with the files of KUBECONFIG
+	if _, err := kubeconfigLoader(&Options{Kubeconfig: first, Context: "second"}).ClientConfig(); err == nil {
+		t.Errorf("context of KUBECONFIG used with an explicit kubeconfig")
+	}
+}
+
+func TestCheckExpectedDeletionsWithoutEvents(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		events int
+		want   string
+	}{
+		{"no namespaces matched", "", 0, "no namespaces matched the filters"},
+		{"empty input", "stdin", 0, "no events were read from stdin"},
+		{"empty plan", "plan.jsonl", 0, "no events were read from plan.jsonl"},
+		{"no events deleted from input", "stdin", 2, "no events were affected out of 2 events, check the filters"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkExpectedDeletions(&cleanup.Config{}, &cleanup.Statistics{TotalEvents: tt.events}, &Options{FailOnZero: true, Input: tt.input})
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("error = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package cleanup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	ApprovalFailOpen   = "open"
+	ApprovalFailClosed = "closed"
+)
+
+// ApprovalRequest is posted to the approval webhook with the events selected in a namespace.
+type ApprovalRequest struct {
+	RunID     string          `json:"runID"`
+	Namespace string          `json:"namespace"`
+	Action    string          `json:"action"`
+	Events    []ApprovalEvent `json:"events"`
+}
+
+// ApprovalEvent describes a single selected event of an ApprovalRequest.
+type ApprovalEvent struct {
+	Name      string    `json:"name"`
+	Reason    string    `json:"reason,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ApprovalResponse is returned by the approval webhook. Only the listed events are deleted.
+type ApprovalResponse struct {
+	Approved []string `json:"approved"`
+}
+
+// ApprovalWebhook lets an external service veto the deletion of selected events.
+type ApprovalWebhook struct {
+	URL string
+	// FailMode decides what happens if the webhook fails: 'open' proceeds with all events,
+	// 'closed' fails the namespace.
+	FailMode string
+	// Client is used for the requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+// approve returns the candidates approved by the webhook.
+func (w *ApprovalWebhook) approve(ctx context.Context, runID, namespace, action string, cands []candidate) ([]candidate, error) {
+	approved, err := w.call(ctx, ApprovalRequest{RunID: runID, Namespace: namespace, Action: action, Events: approvalEvents(cands)})
+	if err != nil {
+		if w.FailMode == ApprovalFailOpen {
+			return cands, nil
+		}
+		return nil, err
+	}
+	names := make(map[string]bool, len(approved.Approved))
+	for _, name := range approved.Approved {
+		names[name] = true
+	}
+	var result []candidate
+	for _, cand := range cands {
+		if names[cand.name] {
+			result = append(result, cand)
+		}
+	}
+	return result, nil
+}
+
+func (w *ApprovalWebhook) call(ctx context.Context, approvalReq ApprovalRequest) (*ApprovalResponse, error) {
+	body, err := json.Marshal(approvalReq)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error calling approval webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("approval webhook returned status %s", resp.Status)
+	}
+	approved := &ApprovalResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(approved); err != nil {
+		return nil, fmt.Errorf("error decoding approval webhook response: %w", err)
+	}
+	return approved, nil
+}
+
+func approvalEvents(cands []candidate) []ApprovalEvent {
+	events := make([]ApprovalEvent, len(cands))
+	for i, cand := range cands {
+		events[i] = ApprovalEvent{Name: cand.name, Reason: cand.reason, Timestamp: cand.timestamp}
+	}
+	return events
+}
@@ -0,0 +1,87 @@
+package cleanup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	auditOutcomeSuccess = "success"
+	auditOutcomeFailure = "failure"
+	auditOutcomeDryRun  = "dry-run"
+	auditOutcomeChanged = "changed"
+)
+
+// AuditRecord is a single entry of the audit log.
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	RunID     string    `json:"runID"`
+	Actor     string    `json:"actor,omitempty"`
+	Action    string    `json:"action"`
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	Reason    string    `json:"reason,omitempty"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// AuditLog is an append-only log of all actions on events, written as one JSON record per line.
+// It is safe for concurrent use.
+type AuditLog struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+	runID   string
+	actor   string
+}
+
+// OpenAuditLog opens the audit log file in append mode.
+// The run ID and actor are added to all records.
+func OpenAuditLog(path, runID, actor string) (*AuditLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening audit log: %w", err)
+	}
+	return &AuditLog{file: file, encoder: json.NewEncoder(file), runID: runID, actor: actor}, nil
+}
+
+// Record appends the record, filling in the time, run ID and actor.
+func (l *AuditLog) Record(r AuditRecord) error {
+	r.Time = time.Now().UTC()
+	r.RunID = l.runID
+	r.Actor = l.actor
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.encoder.Encode(&r)
+}
+
+// Close flushes the audit log to disk and closes it.
+func (l *AuditLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.file.Sync(); err != nil {
+		l.file.Close()
+		return fmt.Errorf("error syncing audit log: %w", err)
+	}
+	return l.file.Close()
+}
+
+// WhoAmI returns the user name the apiserver authenticates the client as, including impersonation.
+func WhoAmI(ctx context.Context, clientset kubernetes.Interface) (string, error) {
+	review, err := clientset.AuthenticationV1().SelfSubjectReviews().Create(ctx, &authenticationv1.SelfSubjectReview{}, metav1.CreateOptions{})
+	if err != nil {
+		return "", err
+	}
+	return review.Status.UserInfo.Username, nil
+}
@@ -0,0 +1,77 @@
+package cleanup
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	CELModeAnd = "and"
+	CELModeOr  = "or"
+)
+
+// CELFilter is a compiled CEL expression deciding if an event is a candidate for deletion.
+type CELFilter struct {
+	program cel.Program
+	mode    string
+}
+
+// NewCELFilter compiles the expression. It must evaluate to a bool.
+// The expression has access to the variable `event`, a map with the keys
+// reason, type, message, count, ageSeconds and involvedObject (kind, name, namespace).
+func NewCELFilter(expression, mode string) (*CELFilter, error) {
+	switch mode {
+	case CELModeAnd, CELModeOr:
+	default:
+		return nil, fmt.Errorf("invalid cel-mode: %s", mode)
+	}
+	env, err := cel.NewEnv(cel.Variable("event", cel.MapType(cel.StringType, cel.DynType)))
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := env.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if ast.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("expression must evaluate to bool, but has type %s", ast.OutputType())
+	}
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, err
+	}
+	return &CELFilter{program: program, mode: mode}, nil
+}
+
+// apply combines the result of the age check with the result of the expression.
+// If the expression cannot be evaluated for the event, it is treated as not matching.
+func (f *CELFilter) apply(event *corev1.Event, expired bool, age time.Duration) (bool, error) {
+	if f.mode == CELModeAnd && !expired {
+		return false, nil
+	}
+	if f.mode == CELModeOr && expired {
+		return true, nil
+	}
+	out, _, err := f.program.Eval(map[string]any{
+		"event": map[string]any{
+			"reason":     event.Reason,
+			"type":       event.Type,
+			"message":    event.Message,
+			"count":      int64(event.Count),
+			"ageSeconds": int64(age.Seconds()),
+			"involvedObject": map[string]any{
+				"kind":      event.InvolvedObject.Kind,
+				"name":      event.InvolvedObject.Name,
+				"namespace": event.InvolvedObject.Namespace,
+			},
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	matched, ok := out.Value().(bool)
+	return ok && matched, nil
+}
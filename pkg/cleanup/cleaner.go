@@ -0,0 +1,1177 @@
+package cleanup
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// Cleaner cleans up expired events. A Cleaner is meant for a single run, its statistics accumulate over all calls.
+type Cleaner struct {
+	clientset   kubernetes.Interface
+	cfg         *Config
+	stats       *Statistics
+	retryBudget *RetryBudget
+	pacer       *pacer
+	clock       Clock
+	// outMu serializes the writes to out, as namespaces and their deletes are processed concurrently.
+	outMu sync.Mutex
+	out   io.Writer
+	// deleteSlots bounds the deletes in flight over all namespaces. It is nil if unlimited.
+	deleteSlots chan struct{}
+	// namespaceRetention holds the retention annotated on the selected namespaces.
+	namespaceRetention map[string]time.Duration
+	involvedObjects    *involvedObjects
+	// explained counts the events explained so far.
+	explained atomic.Int64
+}
+
+// NewCleaner creates a Cleaner for the given client and configuration.
+func NewCleaner(clientset kubernetes.Interface, cfg *Config) *Cleaner {
+	out := cfg.Out
+	if out == nil {
+		out = os.Stdout
+	}
+	clock := cfg.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+	var deleteSlots chan struct{}
+	if cfg.MaxConcurrentDeletes > 0 {
+		deleteSlots = make(chan struct{}, cfg.MaxConcurrentDeletes)
+	}
+	return &Cleaner{
+		clientset:   clientset,
+		cfg:         cfg,
+		stats:       &Statistics{},
+		retryBudget: &RetryBudget{Limit: cfg.RetryBudget},
+		pacer:       &pacer{interval: cfg.MinDeleteInterval, clock: clock},
+		clock:       clock,
+		out:         out,
+
+		deleteSlots:        deleteSlots,
+		namespaceRetention: map[string]time.Duration{},
+		involvedObjects:    &involvedObjects{cache: map[string]involvedEntry{}},
+	}
+}
+
+// ErrNamespaceBlocked is returned if an admission webhook denies modifying the events of a namespace.
+var ErrNamespaceBlocked = stderrors.New("namespace blocked by admission webhook")
+
+// NamespaceResult is the outcome of cleaning up a single namespace.
+type NamespaceResult struct {
+	Namespace   string
+	TotalEvents int
+	// SelectedEvents are the events deleted, marked or counted as expired, depending on the mode.
+	SelectedEvents int
+	// Skipped is true if nothing was done as the namespace exceeds the event count limit.
+	Skipped bool
+}
+
+// NamespaceError is an error which occurred while cleaning up the events of a namespace.
+type NamespaceError struct {
+	Namespace string
+	Err       error
+}
+
+func (e *NamespaceError) Error() string {
+	return fmt.Sprintf("error cleaning up events in namespace %s: %s", e.Namespace, e.Err)
+}
+
+func (e *NamespaceError) Unwrap() error {
+	return e.Err
+}
+
+// Kind classifies the underlying error by its API status reason, e.g. "Forbidden" or "Timeout".
+func (e *NamespaceError) Kind() string {
+	if reason := errors.ReasonForError(e.Err); reason != metav1.StatusReasonUnknown {
+		return string(reason)
+	}
+	if stderrors.Is(e.Err, context.DeadlineExceeded) {
+		return string(metav1.StatusReasonTimeout)
+	}
+	return "Unknown"
+}
+
+// Statistics returns the statistics collected so far.
+func (c *Cleaner) Statistics() *Statistics {
+	c.stats.setRetries(c.retryBudget.Used(), c.retryBudget.UsedByCause())
+	return c.stats
+}
+
+// Run cleans up the events of all selected namespaces.
+// Failures in single namespaces do not stop the cleanup, they are collected in the statistics instead.
+// An error is only returned if the namespaces cannot be determined, or if the context is canceled.
+// In the latter case, the remaining namespaces are not cleaned up.
+func (c *Cleaner) Run(ctx context.Context) (*Statistics, error) {
+	namespaces, err := c.selectNamespaces(ctx)
+	if err != nil {
+		return c.Statistics(), err
+	}
+	if len(namespaces) == 0 {
+		c.logf("No namespaces matched the filters (%s)\n", c.describeNamespaceFilters())
+	}
+	workers := c.namespaceConcurrency(len(namespaces))
+	if workers > 1 || c.cfg.AutoConcurrencyQPS > 0 {
+		c.logf("Namespace concurrency: %d\n", workers)
+	}
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ns := range queue {
+				if ctx.Err() != nil {
+					continue
+				}
+				if c.cfg.MaxTotalEvents > 0 && c.stats.totalEvents() >= c.cfg.MaxTotalEvents {
+					c.stats.addTruncated(ns)
+					continue
+				}
+				c.runNamespace(ctx, ns)
+			}
+		}()
+	}
+dispatch:
+	for _, ns := range namespaces {
+		select {
+		case queue <- ns:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(queue)
+	wg.Wait()
+	if n := len(c.stats.TruncatedNamespaces); n > 0 {
+		c.logf("Stopped after scanning %d events (max-total-events: %d), %d namespaces were not scanned\n", c.stats.TotalEvents, c.cfg.MaxTotalEvents, n)
+	}
+	if err := ctx.Err(); err != nil {
+		return c.Statistics(), err
+	}
+	return c.Statistics(), nil
+}
+
+// runNamespace cleans up a namespace within the namespace timeout and records its failure.
+func (c *Cleaner) runNamespace(ctx context.Context, ns string) {
+	c.logf("Namespace: %s\n", ns)
+	nsCtx, cancel := ctx, context.CancelFunc(func() {})
+	if c.cfg.NamespaceTimeout > 0 {
+		nsCtx, cancel = context.WithTimeout(ctx, c.cfg.NamespaceTimeout)
+	}
+	defer cancel()
+	_, err := c.CleanNamespace(nsCtx, ns)
+	if stderrors.Is(err, ErrNamespaceBlocked) {
+		c.logf("Skipping namespace %s: %s\n", ns, err)
+		c.stats.AddBlockedNamespace(ns)
+	} else if err != nil && ctx.Err() == nil && stderrors.Is(nsCtx.Err(), context.DeadlineExceeded) {
+		c.logf("Abandoning namespace %s after %s: %s\n", ns, c.cfg.NamespaceTimeout, err)
+		c.stats.AddTimedOutNamespace(ns)
+	} else if err != nil {
+		nsErr := &NamespaceError{Namespace: ns, Err: err}
+		c.logf("%s\n", nsErr)
+		c.stats.AddFailure(nsErr)
+	}
+}
+
+// namespaceConcurrency returns the number of namespaces cleaned up concurrently.
+func (c *Cleaner) namespaceConcurrency(namespaces int) int {
+	workers := c.cfg.NamespaceConcurrency
+	if c.cfg.AutoConcurrencyQPS > 0 {
+		workers = AutoNamespaceConcurrency(namespaces, c.cfg.AutoConcurrencyQPS)
+	}
+	return max(1, min(workers, namespaces))
+}
+
+const (
+	// callsPerWorker is the request rate a single worker is expected to reach, as it waits for each response.
+	callsPerWorker = 10
+	// maxAutoConcurrency bounds the namespaces cleaned up concurrently if the concurrency is derived automatically.
+	maxAutoConcurrency = 16
+)
+
+// AutoNamespaceConcurrency derives the number of namespaces to clean up concurrently from the number of namespaces
+// and the request rate available. More workers than needed to use up the rate would only wait for the rate limiter.
+func AutoNamespaceConcurrency(namespaces int, qps float64) int {
+	workers := int(math.Ceil(qps / callsPerWorker))
+	return max(1, min(workers, namespaces, maxAutoConcurrency))
+}
+
+func (c *Cleaner) logf(format string, args ...any) {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	fmt.Fprintf(c.out, format, args...)
+}
+
+// selectNamespaces returns the names of the namespaces to clean up.
+// If exactly one namespace and no label selector is specified, the namespaces are not listed at all.
+func (c *Cleaner) selectNamespaces(ctx context.Context) ([]string, error) {
+	cfg := c.cfg
+	if len(cfg.Namespaces) == 1 && cfg.NamespaceLabelSelector == "" {
+		if cfg.RespectNamespaceAnnotations {
+			ns, err := c.clientset.CoreV1().Namespaces().Get(ctx, cfg.Namespaces[0], metav1.GetOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("error getting namespace: %w", err)
+			}
+			c.readNamespaceRetention(ns)
+		}
+		return cfg.Namespaces, nil
+	}
+
+	namespaceList, err := c.clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: cfg.NamespaceLabelSelector})
+	if err != nil {
+		return nil, fmt.Errorf("error listing namespaces: %w", err)
+	}
+	selected := make(map[string]bool, len(cfg.Namespaces))
+	for _, ns := range cfg.Namespaces {
+		selected[ns] = true
+	}
+	now := c.clock.Now()
+	var namespaces []string
+	skipped := 0
+	for i := range namespaceList.Items {
+		ns := &namespaceList.Items[i]
+		if len(selected) > 0 && !selected[ns.Name] {
+			continue
+		}
+		if cfg.RespectNamespaceAnnotations {
+			c.readNamespaceRetention(ns)
+		}
+		if cfg.SkipNewNamespaces && ns.CreationTimestamp.Time.After(c.latestCutoffTime(ns.Name, now)) {
+			skipped++
+			continue
+		}
+		namespaces = append(namespaces, ns.Name)
+	}
+	if skipped > 0 {
+		c.logf("Skipped %d namespaces created after the cutoff time\n", skipped)
+	}
+	if cfg.SampleNamespaces > 0 && len(namespaces) > cfg.SampleNamespaces {
+		c.stats.setSampled(len(namespaces))
+		rand.Shuffle(len(namespaces), func(i, j int) {
+			namespaces[i], namespaces[j] = namespaces[j], namespaces[i]
+		})
+		namespaces = namespaces[:cfg.SampleNamespaces]
+		c.logf("Sampling %d of %d namespaces\n", len(namespaces), c.stats.SampledFrom)
+	}
+	c.orderNamespaces(ctx, namespaces)
+	return namespaces, nil
+}
+
+// describeNamespaceFilters lists the active namespace filters for messages.
+func (c *Cleaner) describeNamespaceFilters() string {
+	cfg := c.cfg
+	var filters []string
+	if len(cfg.Namespaces) > 0 {
+		filters = append(filters, "namespace="+strings.Join(cfg.Namespaces, ","))
+	}
+	if cfg.NamespaceLabelSelector != "" {
+		filters = append(filters, "namespace-label-selector="+cfg.NamespaceLabelSelector)
+	}
+	if cfg.SkipNewNamespaces {
+		filters = append(filters, "skip-namespaces-newer-than")
+	}
+	if len(filters) == 0 {
+		return "no filters, the cluster has no namespaces"
+	}
+	return strings.Join(filters, ", ")
+}
+
+// readNamespaceRetention reads the retention annotation of the namespace.
+// Invalid values are ignored with a warning, values below the minimum retention are raised to it.
+func (c *Cleaner) readNamespaceRetention(ns *corev1.Namespace) {
+	value, ok := ns.Annotations[RetentionAnnotation]
+	if !ok {
+		return
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		c.logf("Warning: ignoring invalid %s annotation %q of namespace %s\n", RetentionAnnotation, value, ns.Name)
+		return
+	}
+	if d < c.cfg.MinRetention {
+		c.logf("Warning: raising retention %s of namespace %s to the minimum of %s\n", d, ns.Name, c.cfg.MinRetention)
+		d = c.cfg.MinRetention
+	}
+	c.namespaceRetention[ns.Name] = d
+}
+
+// cutoffTime returns the cutoff time of the namespace, taking its retention annotation into account.
+func (c *Cleaner) cutoffTime(namespace string, now time.Time) time.Time {
+	if d, ok := c.namespaceRetention[namespace]; ok {
+		return now.Add(-d)
+	}
+	return c.cfg.cutoffTime(now)
+}
+
+// reasonCutoffTimes returns the cutoff times of the reasons with their own retention in the namespace.
+// If the namespace has a retention annotation, the longer of both retentions applies, so that a reason
+// retention never undercuts the retention chosen by the namespace owner.
+func (c *Cleaner) reasonCutoffTimes(namespace string, now time.Time) map[string]time.Time {
+	cutoffs := c.cfg.reasonCutoffTimes(now)
+	if _, ok := c.namespaceRetention[namespace]; !ok {
+		return cutoffs
+	}
+	namespaceCutoff := c.cutoffTime(namespace, now)
+	for reason, cutoff := range cutoffs {
+		if namespaceCutoff.Before(cutoff) {
+			cutoffs[reason] = namespaceCutoff
+		}
+	}
+	return cutoffs
+}
+
+// eventSelector returns a function deciding if an event of the namespace is expired at now. It returns the
+// timestamp used for the age check together with the rule deciding it. Filters applied after the scan of
+// the namespace, like protect-recent-per-reason, are not covered.
+func (c *Cleaner) eventSelector(namespace string, now time.Time) func(event *corev1.Event) (bool, time.Time, string) {
+	cfg := c.cfg
+	cutoffTime := c.cutoffTime(namespace, now)
+	reasonCutoffTimes := c.reasonCutoffTimes(namespace, now)
+	rules := c.retainRules(now)
+	return func(event *corev1.Event) (bool, time.Time, string) {
+		if rule := retainedBy(rules, event); rule != "" {
+			return false, time.Time{}, rule
+		}
+		timestamp := eventTimestamp(event, cfg.AgeBasis)
+		cutoff, ok := reasonCutoffTimes[event.Reason]
+		if !ok {
+			cutoff = cutoffTime
+		}
+		selected := timestamp.Before(cutoff)
+		rule := "too new"
+		if selected {
+			rule = "expired"
+		}
+		if ok {
+			rule += " (retention of reason " + event.Reason + ")"
+		}
+		if cfg.CELFilter != nil {
+			var err error
+			byAge := selected
+			if selected, err = cfg.CELFilter.apply(event, selected, now.Sub(timestamp)); err != nil {
+				c.logf("  error evaluating filter-cel for event %s/%s: %s\n", event.Namespace, event.Name, err)
+			}
+			if selected != byAge {
+				rule = "filter-cel"
+			}
+		}
+		switch {
+		case cfg.DeleteAnnotated:
+			selected, rule = isMarkedExpired(event), "delete-annotated"
+		case cfg.MarkOnly && cfg.isMarked(event):
+			// already marked by a previous run
+			selected, rule = false, "already marked"
+		}
+		return selected, timestamp, rule
+	}
+}
+
+// latestCutoffTime returns the latest cutoff time of the namespace over all reasons.
+// No event created after it can be expired.
+func (c *Cleaner) latestCutoffTime(namespace string, now time.Time) time.Time {
+	latest := c.cutoffTime(namespace, now)
+	for _, cutoff := range c.reasonCutoffTimes(namespace, now) {
+		if cutoff.After(latest) {
+			latest = cutoff
+		}
+	}
+	return latest
+}
+
+// orderNamespaces sorts the namespaces in place according to the configured order.
+// For the event-count order, the namespaces with most events come first. The event count
+// is taken from the remaining item count of a list with limit 1, so it may be approximate.
+func (c *Cleaner) orderNamespaces(ctx context.Context, namespaces []string) {
+	switch c.cfg.NamespaceOrder {
+	case NamespaceOrderAPI:
+	case NamespaceOrderEventCount:
+		counts := make(map[string]int64, len(namespaces))
+		for _, ns := range namespaces {
+			list, err := c.clientset.CoreV1().Events(ns).List(ctx, metav1.ListOptions{Limit: 1})
+			if err != nil {
+				c.logf("Cannot count events in namespace %s: %s\n", ns, err)
+				continue
+			}
+			counts[ns] = int64(len(list.Items))
+			if list.RemainingItemCount != nil {
+				counts[ns] += *list.RemainingItemCount
+			}
+		}
+		sort.SliceStable(namespaces, func(i, j int) bool {
+			if counts[namespaces[i]] != counts[namespaces[j]] {
+				return counts[namespaces[i]] > counts[namespaces[j]]
+			}
+			return namespaces[i] < namespaces[j]
+		})
+	default:
+		sort.Strings(namespaces)
+	}
+}
+
+// CleanNamespace cleans up the expired events of a single namespace.
+func (c *Cleaner) CleanNamespace(ctx context.Context, namespace string) (NamespaceResult, error) {
+	cfg := c.cfg
+	c.stats.IncNamespacesScanned()
+	result := NamespaceResult{Namespace: namespace}
+	eventsClient := c.clientset.CoreV1().Events(namespace)
+
+	now := c.clock.Now()
+	cutoffTime := c.cutoffTime(namespace, now)
+
+	streaming := cfg.streamDeletes()
+	var (
+		reasons         map[string]int
+		expiredByReason map[string]int
+		kinds           map[string]int
+		expiredByKind   map[string]int
+		toDelete        []candidate
+		selectedBytes   int64
+		oldestDeleted   time.Time
+		oldestRetained  time.Time
+		whatIf          []int
+		latestByReason  map[string]time.Time
+		futureDated     int
+		explanations    []explanation
+	)
+	// if the namespace may be skipped, the decisions are only explained once it is known if it is skipped
+	deferExplain := cfg.Explain > 0 && cfg.SkipOverLimit && cfg.WarnEventCount > 0
+	explainEvent := func(name string, selected bool, rule string) {
+		if deferExplain {
+			explanations = append(explanations, explanation{name: name, selected: selected, rule: rule})
+			return
+		}
+		c.explain(namespace, name, selected, rule)
+	}
+	progress := &deleteProgress{maxErrors: cfg.MaxNamespaceErrors}
+	reset := func() {
+		if streaming {
+			// the events deleted so far are not listed again, only the retained ones are counted anew
+			result.TotalEvents = result.SelectedEvents
+		} else {
+			result.TotalEvents = 0
+			result.SelectedEvents = 0
+			selectedBytes = 0
+			oldestDeleted = time.Time{}
+		}
+		oldestRetained = time.Time{}
+		futureDated = 0
+		reasons = map[string]int{}
+		expiredByReason = map[string]int{}
+		kinds = map[string]int{}
+		expiredByKind = map[string]int{}
+		latestByReason = map[string]time.Time{}
+		toDelete = nil
+		explanations = nil
+		whatIf = make([]int, len(cfg.WhatIf))
+	}
+	reset()
+	// settle adds the final candidates to the selected events of the statistics, the other candidates
+	// have been dropped by a filter after the scan and are retained
+	settle := func(cands, final []candidate) {
+		kept := make(map[string]bool, len(final))
+		for _, cand := range final {
+			kept[cand.name] = true
+		}
+		for _, cand := range cands {
+			if kept[cand.name] {
+				oldestDeleted = earliest(oldestDeleted, cand.effective)
+				selectedBytes += cand.size
+			} else {
+				oldestRetained = earliest(oldestRetained, cand.effective)
+			}
+		}
+	}
+	record := func() {
+		c.stats.AddTotal(result.TotalEvents)
+		if cfg.MarkOnly {
+			c.stats.AddMarked(result.SelectedEvents)
+		} else {
+			c.stats.AddDeleted(result.SelectedEvents)
+		}
+		c.stats.AddNamespace(namespace, result.TotalEvents, result.SelectedEvents)
+		c.stats.AddKindTotals(kinds)
+		c.stats.AddSelectedBytes(selectedBytes)
+		c.stats.AddOldest(oldestDeleted, oldestRetained)
+		c.stats.AddWhatIf(whatIf)
+		c.stats.AddFutureDated(futureDated)
+	}
+	if err := c.listEvents(ctx, eventsClient, reset, func(events []corev1.Event) error {
+		result.TotalEvents += len(events)
+		// events may have been created since the scan started, so they are judged at the time the page was
+		// received. The future-dated rule and count, the filters and the age checks share this reference time.
+		received := c.clock.Now()
+		rules := c.retainRules(received)
+		selectEvent := c.eventSelector(namespace, received)
+		for i := range events {
+			event := &events[i]
+			reasons[event.Reason]++
+			kinds[event.InvolvedObject.Kind]++
+			if isFutureDated(event, received) {
+				futureDated++
+			}
+			if len(whatIf) > 0 && retainedBy(rules, event) == "" {
+				timestamp := eventTimestamp(event, cfg.AgeBasis)
+				for j, d := range cfg.WhatIf {
+					if timestamp.Before(received.Add(-d)) {
+						whatIf[j]++
+					}
+				}
+			}
+			selected, timestamp, rule := selectEvent(event)
+			explainEvent(event.Name, selected, rule)
+			effective := effectiveEventTime(event)
+			if cfg.ProtectRecentPerReason > 0 && effective.After(latestByReason[event.Reason]) {
+				latestByReason[event.Reason] = effective
+			}
+			if !selected {
+				oldestRetained = earliest(oldestRetained, effective)
+				continue
+			}
+			result.SelectedEvents++
+			var size int64
+			if cfg.EstimateSize {
+				// the protobuf size is close to what is stored in etcd
+				size = int64(event.Size())
+			}
+			if cfg.CountOnly {
+				if cfg.ByReason {
+					expiredByReason[event.Reason]++
+				}
+				expiredByKind[event.InvolvedObject.Kind]++
+				if cfg.ProtectRecentPerReason == 0 {
+					// no filter is applied after the scan
+					oldestDeleted = earliest(oldestDeleted, effective)
+					selectedBytes += size
+					continue
+				}
+			}
+			cand := candidate{
+				name:            event.Name,
+				reason:          event.Reason,
+				kind:            event.InvolvedObject.Kind,
+				resourceVersion: event.ResourceVersion,
+				timestamp:       timestamp,
+				effective:       effective,
+				size:            size,
+			}
+			if cfg.DryRunTemplate != nil {
+				cand.event = event
+			}
+			if cfg.SkipIfObjectModifiedWithin > 0 {
+				involved := event.InvolvedObject
+				cand.involved = &involved
+			}
+			toDelete = append(toDelete, cand)
+		}
+		if !streaming || len(toDelete) == 0 {
+			return nil
+		}
+		approved, err := c.approveCandidates(ctx, namespace, toDelete)
+		if err != nil {
+			return err
+		}
+		result.SelectedEvents -= len(toDelete) - len(approved)
+		settle(toDelete, approved)
+		toDelete = nil
+		return c.deleteCandidates(ctx, eventsClient, namespace, approved, cutoffTime, now, progress)
+	}); err != nil {
+		if streaming {
+			record()
+			result.SelectedEvents -= c.finishDeletes(ctx, eventsClient, namespace, result.SelectedEvents, cutoffTime, now, progress)
+		}
+		return result, err
+	}
+	total := result.TotalEvents
+	candidates := toDelete
+
+	if cfg.ProtectRecentPerReason > 0 {
+		var kept []candidate
+		for _, cand := range toDelete {
+			if cand.effective.After(latestByReason[cand.reason].Add(-cfg.ProtectRecentPerReason)) {
+				explainEvent(cand.name, false, "protect-recent-per-reason")
+				result.SelectedEvents--
+				if cfg.CountOnly && cfg.ByReason {
+					if expiredByReason[cand.reason]--; expiredByReason[cand.reason] == 0 {
+						delete(expiredByReason, cand.reason)
+					}
+				}
+				if cfg.CountOnly {
+					expiredByKind[cand.kind]--
+				}
+				continue
+			}
+			kept = append(kept, cand)
+		}
+		toDelete = kept
+	}
+
+	overLimit := cfg.WarnEventCount > 0 && total > cfg.WarnEventCount
+	if overLimit {
+		c.warnEventCount(reasons, total, namespace)
+	}
+	skipped := overLimit && cfg.SkipOverLimit
+	for _, e := range explanations {
+		if skipped && e.selected {
+			c.explainDecision(namespace, e.name, "Skipped", "skip-over-limit ("+e.rule+")")
+		} else {
+			c.explain(namespace, e.name, e.selected, e.rule)
+		}
+	}
+	if skipped {
+		// the events are retained, but the statistics independent of the deletion are recorded
+		settle(candidates, nil)
+		c.stats.AddTotal(total)
+		c.stats.AddOldest(time.Time{}, earliest(oldestRetained, oldestDeleted))
+		c.stats.AddNamespace(namespace, total, 0)
+		c.stats.AddKindTotals(kinds)
+		c.stats.AddWhatIf(whatIf)
+		c.stats.AddFutureDated(futureDated)
+		if cfg.CountOnly && len(expiredByReason) > 0 {
+			c.stats.AddExpiredByReason(expiredByReason)
+		}
+		c.logf("Skipping deletion in namespace %s (total: %d events)\n", namespace, total)
+		return NamespaceResult{Namespace: namespace, TotalEvents: total, Skipped: true}, nil
+	}
+
+	if cfg.SkipIfObjectModifiedWithin > 0 && len(toDelete) > 0 {
+		kept := c.retainModifiedObjects(ctx, namespace, toDelete, now)
+		result.SelectedEvents -= len(toDelete) - len(kept)
+		toDelete = kept
+	}
+
+	if !streaming && !cfg.DryRun && !cfg.CountOnly && len(toDelete) > 0 {
+		approved, err := c.approveCandidates(ctx, namespace, toDelete)
+		if err != nil {
+			c.stats.AddTotal(total)
+			c.stats.AddNamespace(namespace, total, 0)
+			c.stats.AddKindTotals(kinds)
+			return NamespaceResult{Namespace: namespace, TotalEvents: total}, err
+		}
+		toDelete = approved
+		result.SelectedEvents = len(approved)
+	}
+
+	if !streaming {
+		settle(candidates, toDelete)
+	}
+	record()
+	if cfg.CountOnly {
+		if len(expiredByReason) > 0 {
+			c.stats.AddExpiredByReason(expiredByReason)
+		}
+		for kind, n := range expiredByKind {
+			c.stats.AddKindDeleted(kind, n)
+		}
+		c.logf("Found %d expired events in namespace %s (total: %d events)\n", result.SelectedEvents, namespace, total)
+		return result, nil
+	}
+	verb, done := "delete", "Deleted"
+	if cfg.MarkOnly {
+		verb, done = "mark", "Marked"
+	}
+	if streaming {
+		result.SelectedEvents -= c.finishDeletes(ctx, eventsClient, namespace, result.SelectedEvents, cutoffTime, now, progress)
+		c.logf("%s %d events in namespace %s (total: %d events)\n", done, result.SelectedEvents, namespace, total)
+		return result, nil
+	}
+	if len(toDelete) == 0 {
+		c.logf("No events to %s in namespace %s (total: %d events)\n", verb, namespace, total)
+		return result, nil
+	}
+	c.logf("Found %d events to %s in namespace %s (total: %d events)\n", len(toDelete), verb, namespace, total)
+	if cfg.DryRun {
+		if cfg.VerifyPermissions {
+			if err := c.verifyPermissions(ctx, eventsClient, toDelete); err != nil {
+				return result, err
+			}
+		}
+		for _, cand := range toDelete {
+			c.audit(verb, namespace, cand, auditOutcomeDryRun, nil)
+			if cfg.Plan != nil {
+				entry := PlanEntry{Namespace: namespace, Name: cand.name, Reason: cand.reason, ResourceVersion: cand.resourceVersion}
+				if err := cfg.Plan.Add(entry); err != nil {
+					return result, fmt.Errorf("error writing plan: %w", err)
+				}
+			}
+			if cfg.DryRunTemplate != nil {
+				c.writeTemplate(cand, now)
+			}
+			c.stats.AddKindDeleted(cand.kind, 1)
+			if !cfg.MarkOnly {
+				c.stats.AddDeletedAge(now.Sub(cand.effective))
+			}
+		}
+		return result, nil
+	}
+	if cfg.BucketDuration > 0 {
+		// oldest events first, so that the buckets are processed in ascending age
+		sort.Slice(toDelete, func(i, j int) bool {
+			return toDelete[i].timestamp.Before(toDelete[j].timestamp)
+		})
+	}
+	err := c.deleteCandidates(ctx, eventsClient, namespace, toDelete, cutoffTime, now, progress)
+	result.SelectedEvents -= c.finishDeletes(ctx, eventsClient, namespace, result.SelectedEvents, cutoffTime, now, progress)
+	if err != nil {
+		return result, err
+	}
+	c.logf("%s %d events in namespace %s\n", done, result.SelectedEvents, namespace)
+	return result, nil
+}
+
+// finishDeletes retries the failed deletes of a namespace once if configured and reports the selected events
+// which have not been deleted, because they finally failed, have changed since they were listed, or have not
+// been attempted after the deletions were aborted. They are not counted as deleted.
+// It returns the number of these events.
+func (c *Cleaner) finishDeletes(ctx context.Context, eventsClient typedcorev1.EventInterface, namespace string, selected int, cutoffTime, now time.Time, progress *deleteProgress) int {
+	failed := progress.failedCands
+	changed := progress.changed
+	processed := progress.processed
+	if processed == selected {
+		return 0
+	}
+	if len(failed) > 0 && c.cfg.RetryFailedAtEnd && progress.err == nil && ctx.Err() == nil {
+		c.logf("  Retrying %d failed events in namespace %s\n", len(failed), namespace)
+		retry := &deleteProgress{maxErrors: math.MaxInt}
+		_ = c.deleteCandidates(ctx, eventsClient, namespace, failed, cutoffTime, now, retry)
+		failed = retry.failedCands
+		changed += retry.changed
+		processed += retry.processed
+	}
+	verb := "delete"
+	if c.cfg.MarkOnly {
+		verb = "mark"
+	}
+	for _, cand := range failed {
+		c.logf("  Failed to %s event %s in namespace %s\n", verb, cand.name, namespace)
+	}
+	if changed > 0 {
+		c.logf("  Skipped %d events in namespace %s, they have changed since they were listed\n", changed, namespace)
+	}
+	n := selected - processed
+	if skipped := n - len(failed) - changed; skipped > 0 {
+		c.logf("  Skipped %d events in namespace %s, the deletions have been aborted\n", skipped, namespace)
+	}
+	if c.cfg.MarkOnly {
+		c.stats.AddMarked(-n)
+	} else {
+		c.stats.AddDeleted(-n)
+	}
+	c.stats.AddNamespace(namespace, 0, -n)
+	c.stats.AddFailedEvents(len(failed))
+	return n
+}
+
+// verifySampleSize is the number of events per namespace deleted with server-side dry run to verify the permissions.
+const verifySampleSize = 3
+
+// verifyPermissions deletes or marks a few candidates with server-side dry run, so that missing
+// permissions or denying admission webhooks are detected without modifying anything.
+func (c *Cleaner) verifyPermissions(ctx context.Context, eventsClient typedcorev1.EventInterface, cands []candidate) error {
+	doing := "deleting"
+	if c.cfg.MarkOnly {
+		doing = "marking"
+	}
+	dryRun := []string{metav1.DryRunAll}
+	for _, cand := range cands[:min(len(cands), verifySampleSize)] {
+		err := c.withRetries(ctx, func() error {
+			var err error
+			if c.cfg.MarkOnly {
+				_, err = eventsClient.Patch(ctx, cand.name, types.MergePatchType, c.cfg.markPatch(), metav1.PatchOptions{DryRun: dryRun, FieldManager: c.cfg.fieldManager()})
+			} else {
+				err = eventsClient.Delete(ctx, cand.name, metav1.DeleteOptions{DryRun: dryRun})
+			}
+			if err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("server dry run of %s event %s failed: %w", doing, cand.name, err)
+		}
+	}
+	return nil
+}
+
+// approveCandidates asks the approval webhook, if configured, which of the candidates may be deleted or marked.
+func (c *Cleaner) approveCandidates(ctx context.Context, namespace string, cands []candidate) ([]candidate, error) {
+	if c.cfg.ApprovalWebhook == nil {
+		return cands, nil
+	}
+	action := "delete"
+	if c.cfg.MarkOnly {
+		action = "mark"
+	}
+	approved, err := c.cfg.ApprovalWebhook.approve(ctx, c.cfg.RunID, namespace, action, cands)
+	if err != nil {
+		return nil, err
+	}
+	if vetoed := len(cands) - len(approved); vetoed > 0 {
+		c.logf("  Approval webhook vetoed %d of %d events in namespace %s\n", vetoed, len(cands), namespace)
+	}
+	return approved, nil
+}
+
+// deleteProgress is the state of the deletions in a namespace.
+type deleteProgress struct {
+	// maxErrors is the number of failed events skipped before the deletions are aborted.
+	maxErrors int
+
+	mu           sync.Mutex
+	processed    int
+	changed      int
+	failedCands  []candidate
+	denialLogged bool
+	err          error
+}
+
+// deleteCandidates deletes or marks the candidates, pausing between age buckets if configured.
+// Up to MaxConcurrentDeletesPerNamespace events are deleted in parallel, limited by the global delete slots.
+// progress tracks the processed and failed events of the namespace over all calls.
+// Failed events are skipped until more than the maximum errors of the progress have failed.
+func (c *Cleaner) deleteCandidates(ctx context.Context, eventsClient typedcorev1.EventInterface, namespace string, cands []candidate, cutoffTime, now time.Time, progress *deleteProgress) error {
+	cfg := c.cfg
+	done := "Deleted"
+	if cfg.MarkOnly {
+		done = "Marked"
+	}
+	workers := make(chan struct{}, max(cfg.MaxConcurrentDeletesPerNamespace, 1))
+	var wg sync.WaitGroup
+	for i, cand := range cands {
+		if cfg.BucketDuration > 0 && i > 0 {
+			prev := ageBucket(cands[i-1].timestamp, cutoffTime, cfg.BucketDuration)
+			if ageBucket(cand.timestamp, cutoffTime, cfg.BucketDuration) != prev {
+				wg.Wait()
+				c.logf("  %s age bucket %d in namespace %s, pausing for %s\n", done, prev, namespace, cfg.BucketPause)
+				if err := sleep(ctx, c.clock, cfg.BucketPause); err != nil {
+					progress.fail(err)
+					break
+				}
+			}
+		}
+		if err := c.pacer.wait(ctx); err != nil {
+			progress.fail(err)
+			break
+		}
+		workers <- struct{}{}
+		if err := c.acquireDeleteSlot(ctx); err != nil {
+			<-workers
+			progress.fail(err)
+			break
+		}
+		if progress.aborted() {
+			c.releaseDeleteSlot()
+			<-workers
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-workers }()
+			defer c.releaseDeleteSlot()
+			c.deleteCandidate(ctx, eventsClient, namespace, cand, now, progress)
+		}()
+	}
+	wg.Wait()
+	return progress.err
+}
+
+// deleteCandidate deletes or marks a single candidate and updates the progress.
+func (c *Cleaner) deleteCandidate(ctx context.Context, eventsClient typedcorev1.EventInterface, namespace string, cand candidate, now time.Time, progress *deleteProgress) {
+	cfg := c.cfg
+	verb, doing, done := "delete", "deleting", "Deleted"
+	if cfg.MarkOnly {
+		verb, doing, done = "mark", "marking", "Marked"
+	}
+	deleteOptions := metav1.DeleteOptions{}
+	if cfg.StrictVersion && cand.resourceVersion != "" {
+		deleteOptions.Preconditions = &metav1.Preconditions{ResourceVersion: &cand.resourceVersion}
+	}
+	changed := false
+	err := c.withRetries(ctx, func() error {
+		var err error
+		if cfg.MarkOnly {
+			_, err = eventsClient.Patch(ctx, cand.name, types.MergePatchType, cfg.markPatch(), metav1.PatchOptions{FieldManager: cfg.fieldManager()})
+		} else {
+			err = eventsClient.Delete(ctx, cand.name, deleteOptions)
+		}
+		if deleteOptions.Preconditions != nil && errors.IsConflict(err) {
+			// the event has been updated since it was listed, e.g. its series is active again
+			changed = true
+			return nil
+		}
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		c.audit(verb, namespace, cand, auditOutcomeFailure, err)
+		denied := isAdmissionDenial(err)
+		progress.mu.Lock()
+		defer progress.mu.Unlock()
+		if denied && !progress.denialLogged {
+			c.logf("  %s denied by admission webhook in namespace %s: %s\n", doing, namespace, err)
+			progress.denialLogged = true
+		}
+		progress.failedCands = append(progress.failedCands, cand)
+		if len(progress.failedCands) > progress.maxErrors && progress.err == nil {
+			if denied {
+				progress.err = fmt.Errorf("%w: %s", ErrNamespaceBlocked, err)
+			} else {
+				progress.err = fmt.Errorf("error %s event %s: %w", doing, cand.name, err)
+			}
+		}
+		return
+	}
+	if changed {
+		c.audit(verb, namespace, cand, auditOutcomeChanged, nil)
+		progress.mu.Lock()
+		defer progress.mu.Unlock()
+		progress.changed++
+		return
+	}
+	c.audit(verb, namespace, cand, auditOutcomeSuccess, nil)
+	c.stats.AddKindDeleted(cand.kind, 1)
+	if !cfg.MarkOnly {
+		c.stats.AddDeletedAge(now.Sub(cand.effective))
+	}
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+	progress.processed++
+	if progress.processed%500 == 0 {
+		c.logf("  %s %d events in namespace %s\n", done, progress.processed, namespace)
+	}
+}
+
+// fail records the error aborting the deletions, unless one has been recorded before.
+func (p *deleteProgress) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+// aborted returns true if the deletions have been aborted due to an error.
+func (p *deleteProgress) aborted() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err != nil
+}
+
+// acquireDeleteSlot waits for a free slot of the global delete concurrency limit.
+func (c *Cleaner) acquireDeleteSlot(ctx context.Context) error {
+	if c.deleteSlots == nil {
+		return nil
+	}
+	select {
+	case c.deleteSlots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *Cleaner) releaseDeleteSlot() {
+	if c.deleteSlots != nil {
+		<-c.deleteSlots
+	}
+}
+
+// maxListRestarts is the maximum number of times a paginated list is restarted after the continue token expired.
+const maxListRestarts = 3
+
+// listEvents lists the events of a namespace and calls handle for each page.
+// If pagination is enabled, the next page is already fetched while the current one is handled,
+// so that at most a few pages are held in memory at the same time.
+// If the continue token expires during pagination, the list is restarted from the beginning
+// after calling reset, so that the handler can discard the pages seen so far.
+// If several field selectors are configured, they are listed one after another and events matching
+// more than one of them are only handled once.
+func (c *Cleaner) listEvents(ctx context.Context, eventsClient typedcorev1.EventInterface, reset func(), handle func([]corev1.Event) error) error {
+	type page struct {
+		items []corev1.Event
+		// restarted is set if the list is restarted. It is closed once the pages before have been handled.
+		restarted chan struct{}
+		err       error
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// done is closed when the pages are not consumed anymore. The context is not used for this,
+	// so that an error page is still delivered if the context is canceled.
+	done := make(chan struct{})
+	defer close(done)
+	pages := make(chan page, 1)
+	send := func(p page) bool {
+		select {
+		case pages <- p:
+			return true
+		case <-done:
+			return false
+		}
+	}
+	selectors := c.cfg.FieldSelectors
+	if len(selectors) == 0 {
+		selectors = []string{""}
+	}
+	go func() {
+		defer close(pages)
+		restarts := 0
+		// listSelector sends the pages of the events matching the field selector. It returns false if the
+		// listing has ended, either due to an error or a restart. A restart is signalled by the restart flag.
+		listSelector := func(selector string) (ok, restart bool) {
+			initialOpts := metav1.ListOptions{
+				FieldSelector:        selector,
+				ResourceVersion:      c.cfg.ResourceVersion,
+				ResourceVersionMatch: metav1.ResourceVersionMatch(c.cfg.ResourceVersionMatch),
+				Limit:                c.cfg.PageSize,
+			}
+			opts := initialOpts
+			for {
+				var eventsList *corev1.EventList
+				err := c.withRetries(ctx, func() error {
+					var listErr error
+					eventsList, listErr = eventsClient.List(ctx, opts)
+					return listErr
+				})
+				if err != nil && errors.IsResourceExpired(err) && opts.Continue != "" && restarts < maxListRestarts {
+					restarts++
+					c.logf("  Continue token expired, restarting list of events (%d/%d)\n", restarts, maxListRestarts)
+					// the list is only restarted after the pages before have been handled, as events deleted
+					// by the handler would otherwise be listed and counted again
+					restarted := make(chan struct{})
+					if !send(page{restarted: restarted}) {
+						return false, false
+					}
+					select {
+					case <-restarted:
+						return false, true
+					case <-done:
+						return false, false
+					}
+				}
+				p := page{err: err}
+				if err == nil {
+					p.items = eventsList.Items
+				}
+				if !send(p) || err != nil {
+					return false, false
+				}
+				if eventsList.Continue == "" {
+					return true, false
+				}
+				// the resource version is fixed by the continue token
+				opts.Continue = eventsList.Continue
+				opts.ResourceVersion = ""
+				opts.ResourceVersionMatch = ""
+			}
+		}
+		for i := 0; i < len(selectors); i++ {
+			ok, restart := listSelector(selectors[i])
+			switch {
+			case restart:
+				// the events of all selectors are listed anew, as the handled ones have been reset
+				i = -1
+			case !ok:
+				return
+			}
+		}
+	}()
+
+	// events matching several selectors are only handled once
+	var seen map[types.UID]bool
+	if len(selectors) > 1 {
+		seen = map[types.UID]bool{}
+	}
+	for p := range pages {
+		if p.err != nil {
+			return fmt.Errorf("error listing events: %w", p.err)
+		}
+		if p.restarted != nil {
+			if seen != nil {
+				clear(seen)
+			}
+			reset()
+			close(p.restarted)
+			continue
+		}
+		items := p.items
+		if seen != nil {
+			items = make([]corev1.Event, 0, len(p.items))
+			for _, event := range p.items {
+				if !seen[event.UID] {
+					seen[event.UID] = true
+					items = append(items, event)
+				}
+			}
+		}
+		if err := handle(items); err != nil {
+			return err
+		}
+	}
+	// the listing may have ended without an error page if the context was canceled in between
+	return ctx.Err()
+}
+
+// warnEventCount logs a warning with the top reasons for a namespace exceeding the event count threshold.
+func (c *Cleaner) warnEventCount(counts map[string]int, total int, namespace string) {
+	c.stats.AddFlaggedNamespace(namespace)
+
+	reasons := make([]string, 0, len(counts))
+	for reason := range counts {
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if counts[reasons[i]] != counts[reasons[j]] {
+			return counts[reasons[i]] > counts[reasons[j]]
+		}
+		return reasons[i] < reasons[j]
+	})
+	if len(reasons) > 5 {
+		reasons = reasons[:5]
+	}
+	top := make([]string, 0, len(reasons))
+	for _, reason := range reasons {
+		top = append(top, fmt.Sprintf("%s=%d", reason, counts[reason]))
+	}
+	c.logf("WARNING: namespace %s has %d events (more than %d), top reasons: %s\n",
+		namespace, total, c.cfg.WarnEventCount, strings.Join(top, ", "))
+}
+
+// audit records the action on the event in the audit log, if configured.
+func (c *Cleaner) audit(action, namespace string, cand candidate, outcome string, actionErr error) {
+	if c.cfg.AuditLog == nil {
+		return
+	}
+	r := AuditRecord{
+		Action:    action,
+		Namespace: namespace,
+		Name:      cand.name,
+		Reason:    cand.reason,
+		Outcome:   outcome,
+	}
+	if actionErr != nil {
+		r.Error = actionErr.Error()
+	}
+	if err := c.cfg.AuditLog.Record(r); err != nil {
+		c.logf("error writing audit log: %s\n", err)
+	}
+}
@@ -0,0 +1,691 @@
+package cleanup
+
+import (
+	"bytes"
+	"context"
+	stderrors "errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newEvent returns an event created the given time ago, modified by the options.
+func newEvent(namespace, name string, age time.Duration, opts ...func(*corev1.Event)) *corev1.Event {
+	created := metav1.NewTime(time.Now().Add(-age))
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         namespace,
+			Name:              name,
+			UID:               types.UID(namespace + "/" + name),
+			CreationTimestamp: created,
+		},
+		InvolvedObject: corev1.ObjectReference{Kind: "Pod", Namespace: namespace, Name: name},
+		LastTimestamp:  created,
+	}
+	for _, opt := range opts {
+		opt(event)
+	}
+	return event
+}
+
+// newNamespace returns a namespace with the labels given as key and value pairs.
+func newNamespace(name string, labels ...string) *corev1.Namespace {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{}}}
+	for i := 0; i+1 < len(labels); i += 2 {
+		ns.Labels[labels[i]] = labels[i+1]
+	}
+	return ns
+}
+
+// newTestCleaner returns a cleaner of the objects with an expiry of one hour, unless set by the config.
+func newTestCleaner(cfg *Config, objects ...runtime.Object) (*Cleaner, *fake.Clientset, *bytes.Buffer) {
+	if cfg.Duration == 0 && cfg.Since.IsZero() {
+		cfg.Duration = time.Hour
+	}
+	if cfg.AgeBasis == "" {
+		cfg.AgeBasis = AgeBasisEffective
+	}
+	out := &bytes.Buffer{}
+	cfg.Out = out
+	clientset := fake.NewClientset(objects...)
+	return NewCleaner(clientset, cfg), clientset, out
+}
+
+// remainingEvents returns the sorted names of the events left in the namespace.
+func remainingEvents(t *testing.T, clientset *fake.Clientset, namespace string) []string {
+	t.Helper()
+	list, err := clientset.CoreV1().Events(namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("listing events: %s", err)
+	}
+	var names []string
+	for _, event := range list.Items {
+		names = append(names, event.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestRunListsNamespacesOnlyIfNeeded(t *testing.T) {
+	tests := []struct {
+		name          string
+		namespaces    []string
+		labelSelector string
+		wantList      bool
+	}{
+		{name: "single namespace", namespaces: []string{"a"}},
+		{name: "single namespace with label selector", namespaces: []string{"a"}, labelSelector: "team=x", wantList: true},
+		{name: "several namespaces", namespaces: []string{"a", "b"}, wantList: true},
+		{name: "all namespaces", wantList: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Namespaces: tt.namespaces, NamespaceLabelSelector: tt.labelSelector}
+			cleaner, clientset, _ := newTestCleaner(cfg, newNamespace("a", "team", "x"), newNamespace("b"), newEvent("a", "old", 2*time.Hour))
+			if _, err := cleaner.Run(context.Background()); err != nil {
+				t.Fatalf("Run: %s", err)
+			}
+			listed := slices.ContainsFunc(clientset.Actions(), func(action k8stesting.Action) bool {
+				return action.Matches("list", "namespaces")
+			})
+			if listed != tt.wantList {
+				t.Errorf("namespaces listed = %t, want %t", listed, tt.wantList)
+			}
+			if got := remainingEvents(t, clientset, "a"); len(got) != 0 {
+				t.Errorf("remaining events = %v, want none", got)
+			}
+		})
+	}
+}
+
+func TestRunReportsNamespaceErrors(t *testing.T) {
+	eventsResource := schema.GroupResource{Resource: "events"}
+	tests := []struct {
+		name     string
+		err      error
+		wantKind string
+	}{
+		{"forbidden", errors.NewForbidden(eventsResource, "", fmt.Errorf("denied")), "Forbidden"},
+		{"timeout", errors.NewTimeoutError("slow apiserver", 1), "Timeout"},
+		{"deadline exceeded", context.DeadlineExceeded, "Timeout"},
+		{"network", fmt.Errorf("connection refused"), "Unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cleaner, clientset, _ := newTestCleaner(&Config{}, newNamespace("a"), newNamespace("b"), newEvent("a", "old", 2*time.Hour))
+			clientset.PrependReactor("list", "events", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if action.GetNamespace() == "b" {
+					return true, nil, tt.err
+				}
+				return false, nil, nil
+			})
+			stats, err := cleaner.Run(context.Background())
+			if err != nil {
+				t.Fatalf("Run: %s", err)
+			}
+			if len(stats.Failures) != 1 {
+				t.Fatalf("failures = %v, want one", stats.Failures)
+			}
+			var nsErr *NamespaceError
+			if !stderrors.As(error(stats.Failures[0]), &nsErr) || nsErr.Namespace != "b" {
+				t.Errorf("failure %v does not carry namespace b", stats.Failures[0])
+			}
+			if !stderrors.Is(nsErr, tt.err) {
+				t.Errorf("failure %v does not wrap %v", nsErr, tt.err)
+			}
+			if kind := nsErr.Kind(); kind != tt.wantKind {
+				t.Errorf("Kind() = %s, want %s", kind, tt.wantKind)
+			}
+			if got := remainingEvents(t, clientset, "a"); len(got) != 0 {
+				t.Errorf("remaining events in namespace a = %v, want none", got)
+			}
+		})
+	}
+}
+
+func TestCleanNamespaceAgeBasis(t *testing.T) {
+	// created long ago, but observed again recently
+	recurring := func(event *corev1.Event) {
+		event.LastTimestamp = metav1.NewTime(time.Now().Add(-30 * time.Minute))
+	}
+	tests := []struct {
+		basis       string
+		wantDeleted bool
+	}{
+		{AgeBasisCreation, true},
+		{AgeBasisLast, false},
+		{AgeBasisEffective, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.basis, func(t *testing.T) {
+			cleaner, clientset, _ := newTestCleaner(&Config{AgeBasis: tt.basis}, newEvent("a", "recurring", 3*time.Hour, recurring))
+			result, err := cleaner.CleanNamespace(context.Background(), "a")
+			if err != nil {
+				t.Fatalf("CleanNamespace: %s", err)
+			}
+			if deleted := result.SelectedEvents == 1; deleted != tt.wantDeleted {
+				t.Errorf("deleted = %t, want %t", deleted, tt.wantDeleted)
+			}
+			if remaining := len(remainingEvents(t, clientset, "a")) == 1; remaining == tt.wantDeleted {
+				t.Errorf("event remaining = %t, want %t", remaining, !tt.wantDeleted)
+			}
+		})
+	}
+}
+
+// cleanEvents cleans up namespace a with the events and returns the names of the remaining ones.
+func cleanEvents(t *testing.T, cfg *Config, events ...*corev1.Event) []string {
+	t.Helper()
+	objects := make([]runtime.Object, len(events))
+	for i, event := range events {
+		objects[i] = event
+	}
+	cleaner, clientset, _ := newTestCleaner(cfg, objects...)
+	if _, err := cleaner.CleanNamespace(context.Background(), "a"); err != nil {
+		t.Fatalf("CleanNamespace: %s", err)
+	}
+	return remainingEvents(t, clientset, "a")
+}
+
+func TestRequireMatchingInvolvedNamespace(t *testing.T) {
+	involvedIn := func(namespace string) func(*corev1.Event) {
+		return func(event *corev1.Event) { event.InvolvedObject.Namespace = namespace }
+	}
+	events := []*corev1.Event{
+		newEvent("a", "same", 2*time.Hour),
+		newEvent("a", "other", 2*time.Hour, involvedIn("b")),
+		newEvent("a", "cluster-scoped", 2*time.Hour, involvedIn("")),
+	}
+	tests := []struct {
+		name    string
+		require bool
+		want    []string
+	}{
+		{"disabled", false, nil},
+		{"enabled", true, []string{"cluster-scoped", "other"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cleanEvents(t, &Config{RequireMatchingInvolvedNamespace: tt.require}, events...)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("remaining events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// paginateEvents lets the clientset list events page by page, with the name of the last event of a page as
+// continue token. The first expirations lists with a continue token fail as if the token had expired.
+func paginateEvents(clientset *fake.Clientset, expirations int) {
+	clientset.PrependReactor("list", "events", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		opts := action.(k8stesting.ListActionImpl).GetListOptions()
+		if opts.Continue != "" && expirations > 0 {
+			expirations--
+			return true, nil, errors.NewResourceExpired("the continue token has expired")
+		}
+		obj, err := clientset.Tracker().List(corev1.SchemeGroupVersion.WithResource("events"), corev1.SchemeGroupVersion.WithKind("Event"), action.GetNamespace())
+		if err != nil {
+			return true, nil, err
+		}
+		items := obj.(*corev1.EventList).Items
+		slices.SortFunc(items, func(a, b corev1.Event) int { return strings.Compare(a.Name, b.Name) })
+		var page corev1.EventList
+		for _, event := range items {
+			if event.Name <= opts.Continue {
+				continue
+			}
+			if opts.Limit > 0 && int64(len(page.Items)) == opts.Limit {
+				page.Continue = page.Items[len(page.Items)-1].Name
+				break
+			}
+			page.Items = append(page.Items, event)
+		}
+		return true, &page, nil
+	})
+}
+
+func TestListRestartsAfterExpiredContinueToken(t *testing.T) {
+	tests := []struct {
+		name        string
+		countOnly   bool
+		expirations int
+		wantErr     bool
+	}{
+		{name: "deleting", expirations: 1},
+		{name: "counting", countOnly: true, expirations: 2},
+		{name: "too many expirations", countOnly: true, expirations: maxListRestarts + 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{PageSize: 2, CountOnly: tt.countOnly}
+			cleaner, clientset, out := newTestCleaner(cfg,
+				newEvent("a", "e1", 2*time.Hour), newEvent("a", "e2", 2*time.Hour), newEvent("a", "e3", 2*time.Hour),
+				newEvent("a", "e4", 2*time.Hour), newEvent("a", "e5", 2*time.Hour))
+			paginateEvents(clientset, tt.expirations)
+			result, err := cleaner.CleanNamespace(context.Background(), "a")
+			if tt.wantErr {
+				if !errors.IsResourceExpired(err) {
+					t.Fatalf("error = %v, want an expired resource", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CleanNamespace: %s", err)
+			}
+			if !strings.Contains(out.String(), "restarting list of events") {
+				t.Errorf("restart not logged:\n%s", out)
+			}
+			if result.TotalEvents != 5 || result.SelectedEvents != 5 {
+				t.Errorf("total, selected = %d, %d, want 5, 5", result.TotalEvents, result.SelectedEvents)
+			}
+			wantRemaining := 0
+			if tt.countOnly {
+				wantRemaining = 5
+			}
+			if got := remainingEvents(t, clientset, "a"); len(got) != wantRemaining {
+				t.Errorf("remaining events = %v, want %d", got, wantRemaining)
+			}
+		})
+	}
+}
+
+func TestRetentionByReason(t *testing.T) {
+	withReason := func(reason string) func(*corev1.Event) {
+		return func(event *corev1.Event) { event.Reason = reason }
+	}
+	events := []*corev1.Event{
+		newEvent("a", "mount-2h", 2*time.Hour, withReason("FailedMount")),
+		newEvent("a", "mount-50h", 50*time.Hour, withReason("FailedMount")),
+		newEvent("a", "pulled-10m", 10*time.Minute, withReason("Pulled")),
+		newEvent("a", "pulled-40m", 40*time.Minute, withReason("Pulled")),
+		newEvent("a", "other-40m", 40*time.Minute, withReason("Scheduled")),
+		newEvent("a", "other-2h", 2*time.Hour, withReason("Scheduled")),
+	}
+	tests := []struct {
+		name      string
+		retention map[string]time.Duration
+		want      []string
+	}{
+		{"global duration only", nil, []string{"other-40m", "pulled-10m", "pulled-40m"}},
+		{
+			"longer and shorter retentions",
+			map[string]time.Duration{"FailedMount": 24 * time.Hour, "Pulled": 30 * time.Minute},
+			[]string{"mount-2h", "other-40m", "pulled-10m"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cleanEvents(t, &Config{Duration: time.Hour, Retention: tt.retention}, events...)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("remaining events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInvolvedNameRegex(t *testing.T) {
+	involving := func(name string) func(*corev1.Event) {
+		return func(event *corev1.Event) { event.InvolvedObject.Name = name }
+	}
+	events := []*corev1.Event{
+		newEvent("a", "controller", 2*time.Hour, involving("ingress-controller-0")),
+		newEvent("a", "controller-canary", 2*time.Hour, involving("ingress-controller-canary")),
+		newEvent("a", "web", 2*time.Hour, involving("web-5d8f")),
+	}
+	tests := []struct {
+		name             string
+		include, exclude string
+		want             []string
+	}{
+		{name: "include", include: "^ingress-controller", want: []string{"web"}},
+		{name: "exclude", exclude: "^ingress-controller", want: []string{"controller", "controller-canary"}},
+		{name: "include and exclude", include: "^ingress-controller", exclude: "canary$", want: []string{"controller-canary", "web"}},
+		{name: "no match", include: "^database", want: []string{"controller", "controller-canary", "web"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{}
+			if tt.include != "" {
+				cfg.InvolvedNameRegex = regexp.MustCompile(tt.include)
+			}
+			if tt.exclude != "" {
+				cfg.ExcludeInvolvedNameRegex = regexp.MustCompile(tt.exclude)
+			}
+			got := cleanEvents(t, cfg, events...)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("remaining events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProtectRecentPerReason(t *testing.T) {
+	withReason := func(reason string) func(*corev1.Event) {
+		return func(event *corev1.Event) { event.Reason = reason }
+	}
+	tests := []struct {
+		name   string
+		events []*corev1.Event
+		want   []string
+		// wantOldestDeleted and wantOldestRetained are the names of the oldest deleted and retained event
+		wantOldestDeleted, wantOldestRetained string
+	}{
+		{
+			name: "window before the latest event of each reason",
+			events: []*corev1.Event{
+				newEvent("a", "a-2h", 2*time.Hour, withReason("A")),
+				newEvent("a", "a-150m", 150*time.Minute, withReason("A")),
+				newEvent("a", "a-5h", 5*time.Hour, withReason("A")),
+				newEvent("a", "b-3h", 3*time.Hour, withReason("B")),
+				newEvent("a", "b-6h", 6*time.Hour, withReason("B")),
+			},
+			want:               []string{"a-150m", "a-2h", "b-3h"},
+			wantOldestDeleted:  "b-6h",
+			wantOldestRetained: "b-3h",
+		},
+		{
+			name: "recent events of a reason move its window",
+			events: []*corev1.Event{
+				newEvent("a", "a-10m", 10*time.Minute, withReason("A")),
+				newEvent("a", "a-2h", 2*time.Hour, withReason("A")),
+				newEvent("a", "b-3h", 3*time.Hour, withReason("B")),
+			},
+			want:               []string{"a-10m", "b-3h"},
+			wantOldestDeleted:  "a-2h",
+			wantOldestRetained: "b-3h",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := make([]runtime.Object, len(tt.events))
+			created := map[string]time.Time{}
+			for i, event := range tt.events {
+				objects[i] = event
+				created[event.Name] = event.CreationTimestamp.Time
+			}
+			cleaner, clientset, _ := newTestCleaner(&Config{ProtectRecentPerReason: time.Hour}, objects...)
+			if _, err := cleaner.CleanNamespace(context.Background(), "a"); err != nil {
+				t.Fatalf("CleanNamespace: %s", err)
+			}
+			got := remainingEvents(t, clientset, "a")
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("remaining events = %v, want %v", got, tt.want)
+			}
+			// the protected events are accounted as retained
+			stats := cleaner.Statistics()
+			if want := created[tt.wantOldestRetained]; !stats.OldestRetained.Equal(want) {
+				t.Errorf("oldest retained = %s, want %s of %s", stats.OldestRetained, want, tt.wantOldestRetained)
+			}
+			if want := created[tt.wantOldestDeleted]; !stats.OldestDeleted.Equal(want) {
+				t.Errorf("oldest deleted = %s, want %s of %s", stats.OldestDeleted, want, tt.wantOldestDeleted)
+			}
+		})
+	}
+}
+
+func TestZeroCreationTimestamp(t *testing.T) {
+	// e.g. mirrored events
+	mirrored := func(lastSeen time.Duration) func(*corev1.Event) {
+		return func(event *corev1.Event) {
+			event.CreationTimestamp = metav1.Time{}
+			event.LastTimestamp = metav1.NewTime(time.Now().Add(-lastSeen))
+		}
+	}
+	events := []*corev1.Event{
+		newEvent("a", "recent", 0, mirrored(10*time.Minute)),
+		newEvent("a", "old", 0, mirrored(2*time.Hour)),
+	}
+	for _, basis := range []string{AgeBasisCreation, AgeBasisLast, AgeBasisEffective} {
+		t.Run(basis, func(t *testing.T) {
+			got := cleanEvents(t, &Config{AgeBasis: basis}, events...)
+			if want := []string{"recent"}; !slices.Equal(got, want) {
+				t.Errorf("remaining events = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSourceComponentRegex(t *testing.T) {
+	fromSource := func(component string) func(*corev1.Event) {
+		return func(event *corev1.Event) { event.Source.Component = component }
+	}
+	fromController := func(controller string) func(*corev1.Event) {
+		return func(event *corev1.Event) { event.ReportingController = controller }
+	}
+	events := []*corev1.Event{
+		newEvent("a", "core-kcm", 2*time.Hour, fromSource("kube-controller-manager")),
+		newEvent("a", "core-kubelet", 2*time.Hour, fromSource("kubelet")),
+		newEvent("a", "new-ccm", 2*time.Hour, fromController("cloud-controller-manager")),
+		newEvent("a", "new-scheduler", 2*time.Hour, fromController("default-scheduler")),
+	}
+	tests := []struct {
+		name             string
+		include, exclude string
+		want             []string
+	}{
+		{name: "include", include: ".*-controller-manager", want: []string{"core-kubelet", "new-scheduler"}},
+		{name: "exclude", exclude: ".*-controller-manager", want: []string{"core-kcm", "new-ccm"}},
+		{name: "include and exclude", include: "-controller-manager$", exclude: "^cloud-", want: []string{"core-kubelet", "new-ccm", "new-scheduler"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{}
+			if tt.include != "" {
+				cfg.SourceComponentRegex = regexp.MustCompile(tt.include)
+			}
+			if tt.exclude != "" {
+				cfg.ExcludeSourceComponentRegex = regexp.MustCompile(tt.exclude)
+			}
+			got := cleanEvents(t, cfg, events...)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("remaining events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFieldSelectorsHandleEventsOnce(t *testing.T) {
+	// the fake clientset ignores field selectors, so that every event matches all of them
+	cleaner, clientset, _ := newTestCleaner(&Config{FieldSelectors: []string{"type=Warning", "reason=BackOff"}},
+		newEvent("a", "e1", 2*time.Hour), newEvent("a", "e2", 10*time.Minute), newEvent("a", "e3", 2*time.Hour))
+	result, err := cleaner.CleanNamespace(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("CleanNamespace: %s", err)
+	}
+	if result.TotalEvents != 3 || result.SelectedEvents != 2 {
+		t.Errorf("total, selected = %d, %d, want 3, 2", result.TotalEvents, result.SelectedEvents)
+	}
+	if got, want := remainingEvents(t, clientset, "a"), []string{"e2"}; !slices.Equal(got, want) {
+		t.Errorf("remaining events = %v, want %v", got, want)
+	}
+}
+
+func TestSkipOverLimit(t *testing.T) {
+	events := []runtime.Object{
+		newEvent("a", "old", 2*time.Hour),
+		newEvent("a", "older", 5*time.Hour),
+		newEvent("a", "recent", 10*time.Minute),
+		newEvent("a", "future", -time.Hour),
+	}
+
+	cleaner, clientset, _ := newTestCleaner(&Config{WarnEventCount: 3, SkipOverLimit: true}, events...)
+	result, err := cleaner.CleanNamespace(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("CleanNamespace: %s", err)
+	}
+	if !result.Skipped || result.SelectedEvents != 0 {
+		t.Errorf("skipped, selected = %t, %d, want true, 0", result.Skipped, result.SelectedEvents)
+	}
+	if got, want := remainingEvents(t, clientset, "a"), []string{"future", "old", "older", "recent"}; !slices.Equal(got, want) {
+		t.Errorf("remaining events = %v, want %v", got, want)
+	}
+
+	// the statistics independent of the deletion are still recorded
+	cleaner, _, out := newTestCleaner(&Config{WarnEventCount: 3, SkipOverLimit: true, DryRun: true, Explain: 10,
+		WhatIf: []time.Duration{3 * time.Hour}}, events...)
+	if _, err := cleaner.CleanNamespace(context.Background(), "a"); err != nil {
+		t.Fatalf("CleanNamespace: %s", err)
+	}
+	stats := cleaner.Statistics()
+	if stats.DeletedEvents != 0 || stats.FutureDated != 1 || !slices.Equal(stats.WhatIf, []int{1}) {
+		t.Errorf("deleted, future-dated, what-if = %d, %d, %v, want 0, 1, [1]", stats.DeletedEvents, stats.FutureDated, stats.WhatIf)
+	}
+	for _, want := range []string{
+		"  Skipped event a/old: skip-over-limit (expired)\n",
+		"  Skipped event a/older: skip-over-limit (expired)\n",
+		"  Retained event a/recent: too new\n",
+		"  Retained event a/future: future-dated\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output does not contain %q:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "Selected") {
+		t.Errorf("skipped events explained as selected:\n%s", out.String())
+	}
+}
+
+// fixedClock is a clock standing still at now. Waits end immediately.
+type fixedClock struct {
+	now time.Time
+}
+
+func (c fixedClock) Now() time.Time {
+	return c.now
+}
+
+func (c fixedClock) After(time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func TestFutureDatedRuleAndCountAgree(t *testing.T) {
+	// the clock of the cleaner is an hour behind the creation of the events
+	clock := fixedClock{now: time.Now().Add(-time.Hour)}
+	cleaner, _, out := newTestCleaner(&Config{DryRun: true, Explain: 10, Clock: clock},
+		newEvent("a", "created-now", 0), newEvent("a", "created-2h-ago", 2*time.Hour))
+	if _, err := cleaner.CleanNamespace(context.Background(), "a"); err != nil {
+		t.Fatalf("CleanNamespace: %s", err)
+	}
+	if got := cleaner.Statistics().FutureDated; got != 1 {
+		t.Errorf("future-dated events = %d, want 1", got)
+	}
+	if want := "  Retained event a/created-now: future-dated\n"; !strings.Contains(out.String(), want) {
+		t.Errorf("output does not contain %q:\n%s", want, out.String())
+	}
+}
+
+func TestCutoffUsesClock(t *testing.T) {
+	// two hours later, the event is older than the duration
+	clock := fixedClock{now: time.Now().Add(2 * time.Hour)}
+	cleaner, clientset, _ := newTestCleaner(&Config{Namespaces: []string{"a"}, Duration: 3 * time.Hour, Clock: clock},
+		newEvent("a", "old", 90*time.Minute), newEvent("a", "recent", 10*time.Minute))
+	found, err := cleaner.Probe(context.Background())
+	if err != nil {
+		t.Fatalf("Probe: %s", err)
+	}
+	if !found {
+		t.Errorf("Probe found no expired event")
+	}
+	if _, err := cleaner.CleanNamespace(context.Background(), "a"); err != nil {
+		t.Fatalf("CleanNamespace: %s", err)
+	}
+	if got, want := remainingEvents(t, clientset, "a"), []string{"recent"}; !slices.Equal(got, want) {
+		t.Errorf("remaining events = %v, want %v", got, want)
+	}
+}
+
+// recordingClock records the waits, which end immediately.
+type recordingClock struct {
+	mu    sync.Mutex
+	waits []time.Duration
+}
+
+func (c *recordingClock) Now() time.Time {
+	return time.Now()
+}
+
+func (c *recordingClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	c.waits = append(c.waits, d)
+	c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	ch <- time.Now()
+	return ch
+}
+
+func TestBucketDeletesOldestFirstWithPauses(t *testing.T) {
+	clock := &recordingClock{}
+	cleaner, clientset, out := newTestCleaner(&Config{BucketDuration: time.Hour, BucketPause: 5 * time.Second, Clock: clock},
+		newEvent("a", "age-2h10m", 130*time.Minute),
+		newEvent("a", "age-5h", 5*time.Hour),
+		newEvent("a", "age-2h20m", 140*time.Minute),
+		newEvent("a", "age-3h30m", 210*time.Minute),
+		newEvent("a", "recent", 10*time.Minute))
+	if _, err := cleaner.CleanNamespace(context.Background(), "a"); err != nil {
+		t.Fatalf("CleanNamespace: %s", err)
+	}
+
+	var deleted []string
+	for _, action := range clientset.Actions() {
+		if action, ok := action.(k8stesting.DeleteAction); ok {
+			deleted = append(deleted, action.GetName())
+		}
+	}
+	if want := []string{"age-5h", "age-3h30m", "age-2h20m", "age-2h10m"}; !slices.Equal(deleted, want) {
+		t.Errorf("deleted events = %v, want %v", deleted, want)
+	}
+	// the buckets are counted from the cutoff one hour ago: 4, 2 and 1
+	if want := []time.Duration{5 * time.Second, 5 * time.Second}; !slices.Equal(clock.waits, want) {
+		t.Errorf("pauses = %v, want %v", clock.waits, want)
+	}
+	for _, want := range []string{
+		"  Deleted age bucket 4 in namespace a, pausing for 5s\n",
+		"  Deleted age bucket 2 in namespace a, pausing for 5s\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output does not contain %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestEmptyInvolvedObject(t *testing.T) {
+	empty := func(event *corev1.Event) { event.InvolvedObject = corev1.ObjectReference{} }
+	events := []*corev1.Event{newEvent("a", "empty", 2*time.Hour, empty), newEvent("a", "pod", 2*time.Hour)}
+	// missing fields never match: include filters retain the event, exclude filters do not
+	tests := []struct {
+		name string
+		cfg  Config
+		want []string
+	}{
+		{"no filter", Config{}, nil},
+		{"require-matching-involved-namespace", Config{RequireMatchingInvolvedNamespace: true}, []string{"empty"}},
+		{"involved-name-regex matching any name", Config{InvolvedNameRegex: regexp.MustCompile(".*")}, []string{"empty"}},
+		{"exclude-involved-name-regex matching any name", Config{ExcludeInvolvedNameRegex: regexp.MustCompile(".*")}, []string{"pod"}},
+		// the kind Pod is not known to the discovery of the fake clientset, so the pod does not exist either
+		{"skip-if-object-modified-within", Config{SkipIfObjectModifiedWithin: time.Hour}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			got := cleanEvents(t, &cfg, events...)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("remaining events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
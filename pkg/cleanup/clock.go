@@ -0,0 +1,39 @@
+package cleanup
+
+import (
+	"context"
+	"time"
+)
+
+// Clock is the source of time of the cleanup: the current time the ages of events are determined
+// with, and all waiting, i.e. the backoff of retries, the pacing of deletes and the pause between
+// age buckets. Only the records of the audit log are stamped with the real time. Tests can set
+// Config.Clock to a fake clock, which returns a channel from After that is fed by the test, so
+// that no real time passes.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// sleep waits for the duration on the clock or until the context is cancelled.
+func sleep(ctx context.Context, clock Clock, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-clock.After(d):
+		return nil
+	}
+}
@@ -0,0 +1,333 @@
+package cleanup
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// ComponentName identifies this tool as user agent and as reporting component of events.
+const ComponentName = "cleanup-events"
+
+// ExpiredAnnotation marks events as expired in mark-only mode.
+const ExpiredAnnotation = "cleanup-events/expired"
+
+// RetentionAnnotation on a namespace overrides the duration for its events if RespectNamespaceAnnotations is set.
+const RetentionAnnotation = "cleanup-events/retention"
+
+const (
+	AgeBasisCreation  = "creation"
+	AgeBasisLast      = "last"
+	AgeBasisEffective = "effective"
+)
+
+const (
+	NamespaceOrderName       = "name"
+	NamespaceOrderEventCount = "event-count"
+	NamespaceOrderAPI        = "api"
+)
+
+// Config configures a cleanup run.
+type Config struct {
+	// Duration is the age after which events are expired. It is ignored if Since is set.
+	Duration time.Duration
+	// Since is an absolute cutoff time. Events older than it are expired.
+	Since       time.Time
+	Retries     int
+	RetryBudget int64
+	DryRun      bool
+	CountOnly   bool
+	ByReason    bool
+	Namespaces  []string
+
+	NamespaceLabelSelector string
+	ResourceVersion        string
+	ResourceVersionMatch   string
+	PageSize               int64
+	BucketDuration         time.Duration
+	BucketPause            time.Duration
+	MinDeleteInterval      time.Duration
+	MinSeriesGap           time.Duration
+	AgeBasis               string
+	CELFilter              *CELFilter
+	IncludeSelf            bool
+	MarkOnly               bool
+	DeleteAnnotated        bool
+	RunID                  string
+	AuditLog               *AuditLog
+	WarnEventCount         int
+	SkipOverLimit          bool
+	SkipNewNamespaces      bool
+	// SampleNamespaces restricts the scan to a random sample of this many namespaces. Only allowed in dry-run or count-only mode.
+	SampleNamespaces int
+	// NamespaceOrder is the order in which the namespaces are processed. If empty, they are sorted by name.
+	NamespaceOrder string
+
+	// RetryHTTPStatus are the HTTP status codes of API errors which are retried. If nil, all errors are retried.
+	RetryHTTPStatus []int
+	// MaxConcurrentDeletesPerNamespace is the number of events deleted in parallel within a namespace.
+	// MaxConcurrentDeletes bounds the deletes in flight over all namespaces cleaned up concurrently. If 0, it is unlimited.
+	MaxConcurrentDeletesPerNamespace int
+	MaxConcurrentDeletes             int
+	// MaxTotalEvents stops Run from scanning further namespaces once this many events have been scanned.
+	// If 0, it is unlimited.
+	MaxTotalEvents int
+	// NamespaceConcurrency is the number of namespaces cleaned up concurrently by Run. If 0, it is 1.
+	// If AutoConcurrencyQPS is set, the concurrency is derived from the namespace count and this request rate instead.
+	NamespaceConcurrency int
+	AutoConcurrencyQPS   float64
+	// NamespaceTimeout bounds the cleanup of each namespace in Run. If 0, it is unlimited.
+	NamespaceTimeout time.Duration
+	// MaxNamespaceErrors is the number of failed deletes skipped before the cleanup of a namespace is aborted.
+	MaxNamespaceErrors int
+	// StrictVersion deletes events only in the resource version they were listed with. Events which have changed
+	// since, e.g. as their series is active again, are skipped.
+	StrictVersion bool
+	// RetryFailedAtEnd retries the failed deletes of a namespace once after all its events have been processed.
+	// It requires MaxNamespaceErrors to be positive.
+	RetryFailedAtEnd bool
+	// RequireMatchingInvolvedNamespace restricts the cleanup to events whose involved object is in the same namespace.
+	RequireMatchingInvolvedNamespace bool
+	// InvolvedNameRegex restricts the cleanup to events whose involved object name matches.
+	InvolvedNameRegex *regexp.Regexp
+	// ExcludeInvolvedNameRegex excludes events whose involved object name matches from the cleanup.
+	ExcludeInvolvedNameRegex *regexp.Regexp
+	// SourceComponentRegex restricts the cleanup to events whose reporting component matches.
+	// ExcludeSourceComponentRegex excludes events whose reporting component matches from the cleanup.
+	SourceComponentRegex        *regexp.Regexp
+	ExcludeSourceComponentRegex *regexp.Regexp
+	// RespectNamespaceAnnotations uses the RetentionAnnotation of a namespace instead of Duration and Since.
+	// Values below MinRetention are raised to it.
+	RespectNamespaceAnnotations bool
+	MinRetention                time.Duration
+	// FieldSelectors restricts the cleanup to events matching any of these field selectors. The events of each
+	// selector are listed separately. If empty, all events are listed.
+	FieldSelectors []string
+	// IncludeReasons restricts the cleanup to events with one of these reasons. If empty, all reasons are included.
+	// ExcludeReasons are the reasons of events which are always retained.
+	IncludeReasons map[string]bool
+	ExcludeReasons map[string]bool
+	// Retention maps event reasons to their own expiry duration, overriding Duration and Since.
+	Retention map[string]time.Duration
+	// Plan receives the events selected in dry-run mode, so that they can be deleted later with ApplyPlan.
+	Plan *Plan
+	// VerifyPermissions deletes a few selected events of each namespace with server-side dry run in dry-run mode,
+	// so that missing permissions or denying admission webhooks are reported as failures.
+	VerifyPermissions bool
+	// Explain is the number of events for which the rule deciding to select or retain them is logged in dry-run mode.
+	Explain int
+	// DryRunTemplate is rendered for each selected event in dry-run mode.
+	DryRunTemplate *template.Template
+	// WhatIf are alternative durations for which the expired events are counted in the same scan.
+	// Per-reason retention and the CEL filter are not applied to them. Only allowed in dry-run or count-only mode.
+	WhatIf []time.Duration
+	// ApprovalWebhook must approve the events before they are deleted or marked.
+	ApprovalWebhook *ApprovalWebhook
+	// MaxCountToDelete retains events which occurred more often than this number regardless of their age. If 0, the count is ignored.
+	MaxCountToDelete int32
+	// ProtectRecentPerReason retains the events of each reason within this window before the latest event of the reason
+	// in a namespace, regardless of their age.
+	ProtectRecentPerReason time.Duration
+	// SkipIfObjectModifiedWithin retains selected events whose involved object has been modified within this duration,
+	// as it is likely under investigation. It costs a Get per involved object. Not allowed in count-only mode.
+	SkipIfObjectModifiedWithin time.Duration
+	// EstimateSize sums up the serialized size of the selected events to estimate the reclaimed storage.
+	EstimateSize bool
+
+	// TTLLabel is set as key=value on the expired events in mark-only mode instead of the ExpiredAnnotation,
+	// so that an external TTL controller can remove them.
+	TTLLabel string
+	// FieldManager is the field manager of the patches of mark-only mode. If empty, ComponentName is used.
+	FieldManager string
+	// Clock is the source of the current time and of all waiting. If nil, the real time is used.
+	Clock Clock
+
+	// Out receives the progress log. If nil, os.Stdout is used.
+	Out io.Writer
+}
+
+// Validate checks the consistency of the configuration.
+func (cfg *Config) Validate() error {
+	if _, err := labels.Parse(cfg.NamespaceLabelSelector); err != nil {
+		return fmt.Errorf("invalid namespace-label-selector: %s", err)
+	}
+	for _, selector := range cfg.FieldSelectors {
+		if _, err := fields.ParseSelector(selector); err != nil {
+			return fmt.Errorf("invalid or-selector %q: %s", selector, err)
+		}
+	}
+	switch metav1.ResourceVersionMatch(cfg.ResourceVersionMatch) {
+	case "":
+	case metav1.ResourceVersionMatchExact, metav1.ResourceVersionMatchNotOlderThan:
+		if cfg.ResourceVersion == "" {
+			return fmt.Errorf("resource-version-match requires resource-version")
+		}
+	default:
+		return fmt.Errorf("invalid resource-version-match: %s", cfg.ResourceVersionMatch)
+	}
+	switch cfg.AgeBasis {
+	case AgeBasisCreation, AgeBasisLast, AgeBasisEffective:
+	default:
+		return fmt.Errorf("invalid age-basis: %s", cfg.AgeBasis)
+	}
+	switch cfg.NamespaceOrder {
+	case "", NamespaceOrderName, NamespaceOrderEventCount, NamespaceOrderAPI:
+	default:
+		return fmt.Errorf("invalid namespace-order: %s", cfg.NamespaceOrder)
+	}
+	if cfg.Plan != nil && (!cfg.DryRun || cfg.MarkOnly || cfg.CountOnly) {
+		return fmt.Errorf("plan requires dry-run and cannot be combined with mark-only or count-only")
+	}
+	if cfg.VerifyPermissions && !cfg.DryRun {
+		return fmt.Errorf("verify-permissions requires dry-run")
+	}
+	if cfg.Explain < 0 {
+		return fmt.Errorf("explain must not be negative")
+	}
+	if cfg.Explain > 0 && !cfg.DryRun {
+		return fmt.Errorf("explain requires dry-run")
+	}
+	if cfg.DryRunTemplate != nil && !cfg.DryRun {
+		return fmt.Errorf("template-file requires dry-run")
+	}
+	if cfg.SampleNamespaces < 0 {
+		return fmt.Errorf("sample must not be negative")
+	}
+	if cfg.SampleNamespaces > 0 && !cfg.DryRun && !cfg.CountOnly {
+		return fmt.Errorf("sample requires dry-run or count-only")
+	}
+	if cfg.ByReason && !cfg.CountOnly {
+		return fmt.Errorf("by-reason requires count-only")
+	}
+	if len(cfg.WhatIf) > 0 && !cfg.DryRun && !cfg.CountOnly {
+		return fmt.Errorf("what-if requires dry-run or count-only")
+	}
+	if cfg.ApprovalWebhook != nil {
+		switch cfg.ApprovalWebhook.FailMode {
+		case ApprovalFailOpen, ApprovalFailClosed:
+		default:
+			return fmt.Errorf("invalid approval-fail-mode: %s", cfg.ApprovalWebhook.FailMode)
+		}
+	}
+	if cfg.MarkOnly && cfg.DeleteAnnotated {
+		return fmt.Errorf("only one of mark-only and delete-annotated may be specified")
+	}
+	if cfg.TTLLabel != "" {
+		if !cfg.MarkOnly {
+			return fmt.Errorf("ttl-label requires mark-only")
+		}
+		key, value, ok := strings.Cut(cfg.TTLLabel, "=")
+		if !ok {
+			return fmt.Errorf("invalid ttl-label, expected key=value: %s", cfg.TTLLabel)
+		}
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("invalid ttl-label key %q: %s", key, strings.Join(errs, ", "))
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return fmt.Errorf("invalid ttl-label value %q: %s", value, strings.Join(errs, ", "))
+		}
+	}
+	if cfg.PageSize < 0 {
+		return fmt.Errorf("page-size must not be negative")
+	}
+	if cfg.RetryBudget < 0 {
+		return fmt.Errorf("retry-budget must not be negative")
+	}
+	if cfg.WarnEventCount < 0 {
+		return fmt.Errorf("warn-namespace-event-count must not be negative")
+	}
+	if cfg.SkipOverLimit && cfg.WarnEventCount == 0 {
+		return fmt.Errorf("skip-over-limit requires warn-namespace-event-count")
+	}
+	if cfg.BucketDuration < 0 || cfg.BucketPause < 0 {
+		return fmt.Errorf("bucket-duration and bucket-pause must not be negative")
+	}
+	if cfg.BucketDuration > 0 && (cfg.DryRun || cfg.CountOnly) {
+		// nothing is deleted, so there is nothing to spread over time
+		return fmt.Errorf("bucket-duration cannot be combined with dry-run or count-only")
+	}
+	if cfg.MinDeleteInterval < 0 {
+		return fmt.Errorf("min-delete-interval must not be negative")
+	}
+	if cfg.MaxCountToDelete < 0 {
+		return fmt.Errorf("max-count-to-delete must not be negative")
+	}
+	if cfg.ProtectRecentPerReason < 0 {
+		return fmt.Errorf("protect-recent-per-reason must not be negative")
+	}
+	if cfg.MaxConcurrentDeletesPerNamespace < 0 || cfg.MaxConcurrentDeletes < 0 {
+		return fmt.Errorf("max-concurrent-deletes-per-namespace and max-concurrent-deletes must not be negative")
+	}
+	if cfg.MaxTotalEvents < 0 {
+		return fmt.Errorf("max-total-events must not be negative")
+	}
+	if cfg.NamespaceConcurrency < 0 || cfg.AutoConcurrencyQPS < 0 {
+		return fmt.Errorf("concurrency must not be negative")
+	}
+	if cfg.NamespaceTimeout < 0 {
+		return fmt.Errorf("delete-timeout-per-namespace must not be negative")
+	}
+	if cfg.MaxNamespaceErrors < 0 {
+		return fmt.Errorf("max-namespace-errors must not be negative")
+	}
+	if cfg.RetryFailedAtEnd && cfg.MaxNamespaceErrors == 0 {
+		// the first failed delete would abort the namespace, so that nothing is left to retry
+		return fmt.Errorf("retry-failed-at-end requires max-namespace-errors to be positive")
+	}
+	if cfg.SkipIfObjectModifiedWithin < 0 {
+		return fmt.Errorf("skip-if-object-modified-within must not be negative")
+	}
+	if cfg.SkipIfObjectModifiedWithin > 0 && cfg.CountOnly {
+		return fmt.Errorf("skip-if-object-modified-within cannot be combined with count-only")
+	}
+	if cfg.MinRetention < 0 {
+		return fmt.Errorf("min-retention must not be negative")
+	}
+	for reason, d := range cfg.Retention {
+		if d < 0 {
+			return fmt.Errorf("retention of reason %s must not be negative", reason)
+		}
+	}
+	return nil
+}
+
+// cutoffTime returns the time before which events are expired.
+func (cfg *Config) cutoffTime(now time.Time) time.Time {
+	if !cfg.Since.IsZero() {
+		return cfg.Since
+	}
+	return now.Add(-cfg.Duration)
+}
+
+// reasonCutoffTimes returns the cutoff times of the reasons with their own retention.
+func (cfg *Config) reasonCutoffTimes(now time.Time) map[string]time.Time {
+	cutoffs := make(map[string]time.Time, len(cfg.Retention))
+	for reason, d := range cfg.Retention {
+		cutoffs[reason] = now.Add(-d)
+	}
+	return cutoffs
+}
+
+// streamDeletes returns true if the events are deleted page by page while listing, instead of
+// collecting all candidates of a namespace first. This bounds the memory to a single page, but is
+// only possible if no option needs to see the whole namespace before deleting.
+func (cfg *Config) streamDeletes() bool {
+	return cfg.PageSize > 0 && !cfg.DryRun && !cfg.CountOnly && !cfg.MarkOnly &&
+		cfg.BucketDuration == 0 && !cfg.SkipOverLimit && cfg.ProtectRecentPerReason == 0 && cfg.SkipIfObjectModifiedWithin == 0
+}
+
+// fieldManager returns the field manager of patches.
+func (cfg *Config) fieldManager() string {
+	if cfg.FieldManager != "" {
+		return cfg.FieldManager
+	}
+	return ComponentName
+}
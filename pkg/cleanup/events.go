@@ -0,0 +1,152 @@
+package cleanup
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+// eventTimestamp returns the timestamp which determines the age of the event for the given age basis.
+func eventTimestamp(event *corev1.Event, basis string) time.Time {
+	switch basis {
+	case AgeBasisCreation:
+		if event.CreationTimestamp.IsZero() {
+			// e.g. mirrored events, which must not be treated as infinitely old
+			return effectiveEventTime(event)
+		}
+		return event.CreationTimestamp.Time
+	case AgeBasisLast:
+		return lastEventTime(event)
+	default:
+		return effectiveEventTime(event)
+	}
+}
+
+// lastEventTime returns the time the event was last observed.
+// Events created with the events.k8s.io API have no lastTimestamp, for them the later of the last observed time
+// of the series and the event time is used, as they are set by different clocks and may disagree.
+// If none of them is set, the creation timestamp is used.
+func lastEventTime(event *corev1.Event) time.Time {
+	if !event.LastTimestamp.IsZero() {
+		return event.LastTimestamp.Time
+	}
+	latest := event.EventTime.Time
+	if event.Series != nil && event.Series.LastObservedTime.After(latest) {
+		latest = event.Series.LastObservedTime.Time
+	}
+	if latest.IsZero() {
+		return event.CreationTimestamp.Time
+	}
+	return latest
+}
+
+// effectiveEventTime returns the latest of all timestamps of the event.
+func effectiveEventTime(event *corev1.Event) time.Time {
+	latest := event.CreationTimestamp.Time
+	for _, t := range []time.Time{event.FirstTimestamp.Time, event.LastTimestamp.Time, event.EventTime.Time} {
+		if t.After(latest) {
+			latest = t
+		}
+	}
+	if event.Series != nil && event.Series.LastObservedTime.After(latest) {
+		latest = event.Series.LastObservedTime.Time
+	}
+	return latest
+}
+
+// earliest returns the earlier of two timestamps, ignoring zero values.
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
+// candidate is an event selected for deletion together with the timestamp used for the age check.
+type candidate struct {
+	name            string
+	reason          string
+	kind            string
+	resourceVersion string
+	timestamp       time.Time
+	// effective is the latest of all timestamps, used for the age statistics
+	effective time.Time
+	// size is the serialized size of the event, only filled if the size is estimated
+	size int64
+	// event is only kept if a dry-run template is rendered, as it retains the whole page in memory
+	event *corev1.Event
+	// involved is only kept if the modification of the involved object is checked
+	involved *corev1.ObjectReference
+}
+
+var markExpiredPatch = []byte(`{"metadata":{"annotations":{"` + ExpiredAnnotation + `":"true"}}}`)
+
+// isMarkedExpired returns true if the event has been marked as expired by a previous mark-only run.
+func isMarkedExpired(event *corev1.Event) bool {
+	return event.Annotations[ExpiredAnnotation] == "true"
+}
+
+// markPatch returns the merge patch marking an event in mark-only mode.
+func (cfg *Config) markPatch() []byte {
+	if cfg.TTLLabel == "" {
+		return markExpiredPatch
+	}
+	key, value, _ := strings.Cut(cfg.TTLLabel, "=")
+	patch, _ := json.Marshal(map[string]any{"metadata": map[string]any{"labels": map[string]string{key: value}}})
+	return patch
+}
+
+// isMarked returns true if the event has already been marked by a previous mark-only run.
+func (cfg *Config) isMarked(event *corev1.Event) bool {
+	if cfg.TTLLabel == "" {
+		return isMarkedExpired(event)
+	}
+	key, value, _ := strings.Cut(cfg.TTLLabel, "=")
+	return event.Labels[key] == value
+}
+
+// isOwnEvent returns true if the event has been reported by this tool.
+func isOwnEvent(event *corev1.Event) bool {
+	return event.Source.Component == ComponentName || event.ReportingController == ComponentName
+}
+
+// sourceComponent returns the component which reported the event. Events created with the events.k8s.io API
+// have no source, for them the reporting controller is used.
+func sourceComponent(event *corev1.Event) string {
+	if event.Source.Component != "" {
+		return event.Source.Component
+	}
+	return event.ReportingController
+}
+
+// futureDatedTolerance is the clock difference tolerated before an event is considered future-dated.
+const futureDatedTolerance = time.Minute
+
+// isFutureDated returns true if the event has been created after now, which indicates skewed clocks.
+// now must not be earlier than the time the event was listed, otherwise events created in between would be reported.
+// The future-dated rule and the count of future-dated events use the time the page of the event was received.
+func isFutureDated(event *corev1.Event, now time.Time) bool {
+	return event.CreationTimestamp.After(now.Add(futureDatedTolerance))
+}
+
+// isAdmissionDenial returns true if the error is a denial of an admission webhook.
+func isAdmissionDenial(err error) bool {
+	return errors.IsForbidden(err) && strings.Contains(err.Error(), "admission webhook")
+}
+
+// isActiveSeries returns true if the event is part of a series which has been observed within the given gap.
+func isActiveSeries(event *corev1.Event, now time.Time, gap time.Duration) bool {
+	if gap <= 0 || event.Series == nil || event.Series.LastObservedTime.IsZero() {
+		return false
+	}
+	return now.Sub(event.Series.LastObservedTime.Time) < gap
+}
+
+// ageBucket returns the index of the age bucket of the given timestamp, counted from the cutoff time.
+// Older timestamps have higher indices.
+func ageBucket(timestamp, cutoffTime time.Time, bucketDuration time.Duration) int64 {
+	return int64(cutoffTime.Sub(timestamp) / bucketDuration)
+}
@@ -0,0 +1,113 @@
+package cleanup
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEventTimestamp(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(hours int) metav1.Time { return metav1.NewTime(base.Add(time.Duration(hours) * time.Hour)) }
+	atMicro := func(hours int) metav1.MicroTime {
+		return metav1.NewMicroTime(base.Add(time.Duration(hours) * time.Hour))
+	}
+
+	tests := []struct {
+		name  string
+		event corev1.Event
+		basis string
+		want  metav1.Time
+	}{
+		{
+			name:  "creation basis ignores a later last timestamp",
+			event: corev1.Event{ObjectMeta: metav1.ObjectMeta{CreationTimestamp: at(0)}, LastTimestamp: at(5)},
+			basis: AgeBasisCreation,
+			want:  at(0),
+		},
+		{
+			name:  "last basis uses the last timestamp",
+			event: corev1.Event{ObjectMeta: metav1.ObjectMeta{CreationTimestamp: at(0)}, FirstTimestamp: at(1), LastTimestamp: at(5)},
+			basis: AgeBasisLast,
+			want:  at(5),
+		},
+		{
+			name:  "last basis falls back to the event time",
+			event: corev1.Event{ObjectMeta: metav1.ObjectMeta{CreationTimestamp: at(0)}, EventTime: atMicro(3)},
+			basis: AgeBasisLast,
+			want:  at(3),
+		},
+		{
+			name:  "last basis falls back to the creation timestamp",
+			event: corev1.Event{ObjectMeta: metav1.ObjectMeta{CreationTimestamp: at(2)}},
+			basis: AgeBasisLast,
+			want:  at(2),
+		},
+		{
+			name: "effective basis uses the latest of all timestamps",
+			event: corev1.Event{
+				ObjectMeta:     metav1.ObjectMeta{CreationTimestamp: at(0)},
+				FirstTimestamp: at(1),
+				LastTimestamp:  at(2),
+				EventTime:      atMicro(4),
+			},
+			basis: AgeBasisEffective,
+			want:  at(4),
+		},
+		{
+			name: "effective basis includes the series",
+			event: corev1.Event{
+				ObjectMeta:    metav1.ObjectMeta{CreationTimestamp: at(0)},
+				LastTimestamp: at(2),
+				Series:        &corev1.EventSeries{Count: 3, LastObservedTime: atMicro(6)},
+			},
+			basis: AgeBasisEffective,
+			want:  at(6),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := eventTimestamp(&tt.event, tt.basis); !got.Equal(tt.want.Time) {
+				t.Errorf("eventTimestamp(%s) = %s, want %s", tt.basis, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSeriesEventTimes(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(hours int) metav1.MicroTime {
+		return metav1.NewMicroTime(base.Add(time.Duration(hours) * time.Hour))
+	}
+	series := func(hours int) *corev1.EventSeries { return &corev1.EventSeries{Count: 2, LastObservedTime: at(hours)} }
+
+	tests := []struct {
+		name      string
+		eventTime metav1.MicroTime
+		series    *corev1.EventSeries
+		want      metav1.MicroTime
+	}{
+		{name: "only event time", eventTime: at(3), want: at(3)},
+		{name: "only last observed time of the series", series: series(4), want: at(4)},
+		{name: "series observed after the event time", eventTime: at(2), series: series(5), want: at(5)},
+		{name: "event time after the series", eventTime: at(6), series: series(5), want: at(6)},
+		{name: "series without last observed time", eventTime: at(3), series: &corev1.EventSeries{Count: 2}, want: at(3)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := &corev1.Event{
+				ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(base)},
+				EventTime:  tt.eventTime,
+				Series:     tt.series,
+			}
+			// all age bases agree for events.k8s.io events, which have no first and last timestamps
+			for _, basis := range []string{AgeBasisLast, AgeBasisEffective} {
+				if got := eventTimestamp(event, basis); !got.Equal(tt.want.Time) {
+					t.Errorf("eventTimestamp(%s) = %s, want %s", basis, got, tt.want)
+				}
+			}
+		})
+	}
+}
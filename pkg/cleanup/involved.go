@@ -0,0 +1,182 @@
+package cleanup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/restmapper"
+)
+
+const (
+	// involvedCacheSize bounds the number of cached involved objects.
+	involvedCacheSize = 10000
+	// involvedCacheTTL is the time an involved object is cached, so that long runs see later modifications.
+	involvedCacheTTL = 5 * time.Minute
+)
+
+// involvedObjects looks up the last modification of the objects involved in events.
+// It is safe for concurrent use.
+type involvedObjects struct {
+	mapperOnce sync.Once
+	mapper     meta.RESTMapper
+	mapperErr  error
+
+	mu sync.Mutex
+	// cache holds the last modification by object, keyed by API version, kind, namespace and name.
+	cache map[string]involvedEntry
+}
+
+type involvedEntry struct {
+	// modified is zero if the object does not exist.
+	modified time.Time
+	expires  time.Time
+}
+
+// cached returns the cached last modification of the object, if it has not expired.
+func (o *involvedObjects) cached(key string, now time.Time) (time.Time, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	entry, ok := o.cache[key]
+	if !ok || !now.Before(entry.expires) {
+		return time.Time{}, false
+	}
+	return entry.modified, true
+}
+
+// add caches the last modification of the object. If the cache is full, the expired entries are evicted,
+// or an arbitrary one if none has expired.
+func (o *involvedObjects) add(key string, modified, now time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.cache) >= involvedCacheSize {
+		for k, entry := range o.cache {
+			if !now.Before(entry.expires) {
+				delete(o.cache, k)
+			}
+		}
+		for k := range o.cache {
+			if len(o.cache) < involvedCacheSize {
+				break
+			}
+			delete(o.cache, k)
+		}
+	}
+	o.cache[key] = involvedEntry{modified: modified, expires: now.Add(involvedCacheTTL)}
+}
+
+// lastModified returns the latest of the creation timestamp and the times of the managed fields of the involved
+// object. It is zero if the object does not exist anymore. cached is true if the result has been cached before.
+func (c *Cleaner) lastModified(ctx context.Context, ref *corev1.ObjectReference) (modified time.Time, cached bool, err error) {
+	if ref.Kind == "" || ref.Name == "" {
+		return time.Time{}, false, nil
+	}
+	key := path.Join(ref.APIVersion, ref.Kind, ref.Namespace, ref.Name)
+	objects := c.involvedObjects
+	if modified, ok := objects.cached(key, c.clock.Now()); ok {
+		return modified, true, nil
+	}
+
+	objects.mapperOnce.Do(func() {
+		var groupResources []*restmapper.APIGroupResources
+		if groupResources, objects.mapperErr = restmapper.GetAPIGroupResources(c.clientset.Discovery()); objects.mapperErr == nil {
+			objects.mapper = restmapper.NewDiscoveryRESTMapper(groupResources)
+		}
+	})
+	if objects.mapperErr != nil {
+		return time.Time{}, false, fmt.Errorf("error discovering resources: %w", objects.mapperErr)
+	}
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	mapping, err := objects.mapper.RESTMapping(gv.WithKind(ref.Kind).GroupKind(), gv.Version)
+	if meta.IsNoMatchError(err) {
+		// the kind is not served anymore, so the object cannot exist
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	restClient := c.clientset.Discovery().RESTClient()
+	if restClient == nil {
+		return time.Time{}, false, fmt.Errorf("no REST client to get %s %s", ref.Kind, ref.Name)
+	}
+	absPath := "/api"
+	if mapping.Resource.Group != "" {
+		absPath = "/apis/" + mapping.Resource.Group
+	}
+	absPath += "/" + mapping.Resource.Version
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		absPath += "/namespaces/" + ref.Namespace
+	}
+	absPath += "/" + mapping.Resource.Resource + "/" + ref.Name
+
+	var body []byte
+	err = c.withRetries(ctx, func() error {
+		var err error
+		// JSON is requested explicitly, as the client may prefer protobuf
+		body, err = restClient.Get().AbsPath(absPath).SetHeader("Accept", "application/json").Do(ctx).Raw()
+		return err
+	})
+	switch {
+	case errors.IsNotFound(err):
+		modified = time.Time{}
+	case err != nil:
+		return time.Time{}, false, err
+	default:
+		var object metav1.PartialObjectMetadata
+		if err := json.Unmarshal(body, &object); err != nil {
+			return time.Time{}, false, fmt.Errorf("error decoding %s %s: %w", ref.Kind, ref.Name, err)
+		}
+		modified = object.CreationTimestamp.Time
+		for _, entry := range object.ManagedFields {
+			if entry.Time != nil && entry.Time.After(modified) {
+				modified = entry.Time.Time
+			}
+		}
+	}
+
+	objects.add(key, modified, c.clock.Now())
+	return modified, false, nil
+}
+
+// retainModifiedObjects retains the candidates whose involved object has been modified within
+// SkipIfObjectModifiedWithin, or whose involved object cannot be looked up.
+func (c *Cleaner) retainModifiedObjects(ctx context.Context, namespace string, cands []candidate, now time.Time) []candidate {
+	var kept []candidate
+	errorLogged := false
+	hits := 0
+	for _, cand := range cands {
+		modified, cached, err := c.lastModified(ctx, cand.involved)
+		if cached {
+			hits++
+		}
+		if err != nil {
+			if !errorLogged {
+				c.logf("  Retaining events whose involved object cannot be looked up in namespace %s: %s\n", namespace, err)
+				errorLogged = true
+			}
+			c.explain(namespace, cand.name, false, "involved object cannot be looked up")
+			continue
+		}
+		if !modified.IsZero() && now.Sub(modified) < c.cfg.SkipIfObjectModifiedWithin {
+			c.explain(namespace, cand.name, false, "skip-if-object-modified-within")
+			continue
+		}
+		kept = append(kept, cand)
+	}
+	c.logf("  Looked up involved objects of %d events in namespace %s (cache hits: %d, misses: %d)\n", len(cands), namespace, hits, len(cands)-hits)
+	if retained := len(cands) - len(kept); retained > 0 {
+		c.logf("  Retaining %d events of recently modified or unknown objects in namespace %s\n", retained, namespace)
+	}
+	return kept
+}
@@ -0,0 +1,33 @@
+package cleanup
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// pacer enforces a minimum interval between consecutive delete calls over the whole run.
+// It is safe for concurrent use.
+type pacer struct {
+	interval time.Duration
+	clock    Clock
+	mu       sync.Mutex
+	next     time.Time
+}
+
+// wait blocks until the next delete call is allowed or the context is cancelled.
+func (p *pacer) wait(ctx context.Context) error {
+	if p == nil || p.interval <= 0 {
+		return nil
+	}
+	p.mu.Lock()
+	now := p.clock.Now()
+	slot := p.next
+	if slot.Before(now) {
+		slot = now
+	}
+	p.next = slot.Add(p.interval)
+	p.mu.Unlock()
+
+	return sleep(ctx, p.clock, slot.Sub(now))
+}
@@ -0,0 +1,185 @@
+package cleanup
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PlanEntry identifies an event selected for deletion in a plan.
+type PlanEntry struct {
+	Namespace       string `json:"namespace"`
+	Name            string `json:"name"`
+	Reason          string `json:"reason,omitempty"`
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+// Plan is a file of the events selected for deletion, written as one JSON entry per line.
+// It is safe for concurrent use.
+type Plan struct {
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// CreatePlan creates or truncates the plan file.
+func CreatePlan(path string) (*Plan, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating plan: %w", err)
+	}
+	return &Plan{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// Add appends an entry to the plan.
+func (p *Plan) Add(entry PlanEntry) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.encoder.Encode(&entry)
+}
+
+// Close flushes the plan to disk and closes it.
+func (p *Plan) Close() error {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.file.Sync(); err != nil {
+		p.file.Close()
+		return fmt.Errorf("error syncing plan: %w", err)
+	}
+	return p.file.Close()
+}
+
+// ReadPlan reads the entries of a plan file.
+func ReadPlan(path string) ([]PlanEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening plan: %w", err)
+	}
+	defer file.Close()
+	var entries []PlanEntry
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry PlanEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("invalid plan entry in line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading plan: %w", err)
+	}
+	return entries, nil
+}
+
+// ReadEventNames reads events to delete as lines of the form namespace/name, e.g. the output of an
+// external filter. The entries have no resource version, so the events are deleted even if they have changed.
+func ReadEventNames(r io.Reader) ([]PlanEntry, error) {
+	var entries []PlanEntry
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		namespace, name, ok := strings.Cut(text, "/")
+		if !ok || namespace == "" || name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("invalid event name in line %d, expected namespace/name: %s", line, text)
+		}
+		entries = append(entries, PlanEntry{Namespace: namespace, Name: name})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading event names: %w", err)
+	}
+	return entries, nil
+}
+
+// ApplyPlan deletes exactly the events of a plan and logs the outcome of each of them. Events which
+// have been deleted or changed since the plan was written are skipped and counted as retained. Events which cannot be deleted
+// are counted as failed, with one failure per namespace. Plans cannot be applied in mark-only or
+// count-only mode.
+func (c *Cleaner) ApplyPlan(ctx context.Context, entries []PlanEntry) (*Statistics, error) {
+	cfg := c.cfg
+	if cfg.MarkOnly || cfg.CountOnly {
+		return nil, fmt.Errorf("a plan cannot be applied in mark-only or count-only mode")
+	}
+	c.stats.AddTotal(len(entries))
+	deleted, gone, changed := 0, 0, 0
+	// failures holds the first error and the number of failed events per namespace, in the order of the first failure
+	type failure struct {
+		namespace string
+		err       error
+		events    int
+	}
+	var failures []*failure
+	failed := map[string]*failure{}
+	finish := func() {
+		c.stats.AddDeleted(deleted)
+		for _, f := range failures {
+			c.stats.AddFailedEvents(f.events)
+			c.stats.AddFailure(&NamespaceError{Namespace: f.namespace, Err: fmt.Errorf("%d planned events could not be deleted, first error: %w", f.events, f.err)})
+		}
+	}
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			finish()
+			return c.Statistics(), err
+		}
+		cand := candidate{name: entry.Name, reason: entry.Reason}
+		if cfg.DryRun {
+			c.audit("delete", entry.Namespace, cand, auditOutcomeDryRun, nil)
+			c.logf("  Would delete event %s/%s\n", entry.Namespace, entry.Name)
+			deleted++
+			continue
+		}
+		if err := c.pacer.wait(ctx); err != nil {
+			finish()
+			return c.Statistics(), err
+		}
+		err := c.withRetries(ctx, func() error {
+			opts := metav1.DeleteOptions{}
+			if entry.ResourceVersion != "" {
+				opts.Preconditions = &metav1.Preconditions{ResourceVersion: &entry.ResourceVersion}
+			}
+			return c.clientset.CoreV1().Events(entry.Namespace).Delete(ctx, entry.Name, opts)
+		})
+		switch {
+		case err == nil:
+			c.audit("delete", entry.Namespace, cand, auditOutcomeSuccess, nil)
+			c.logf("  Deleted event %s/%s\n", entry.Namespace, entry.Name)
+			deleted++
+		case errors.IsNotFound(err):
+			c.logf("  Skipping event %s/%s, it is already gone\n", entry.Namespace, entry.Name)
+			gone++
+		case errors.IsConflict(err):
+			c.logf("  Skipping event %s/%s, it has changed since the plan was written\n", entry.Namespace, entry.Name)
+			changed++
+		default:
+			c.audit("delete", entry.Namespace, cand, auditOutcomeFailure, err)
+			c.logf("  Failed to delete event %s/%s: %s\n", entry.Namespace, entry.Name, err)
+			f := failed[entry.Namespace]
+			if f == nil {
+				f = &failure{namespace: entry.Namespace, err: err}
+				failed[entry.Namespace] = f
+				failures = append(failures, f)
+			}
+			f.events++
+		}
+	}
+	finish()
+	c.logf("Deleted %d of %d planned events (%d already gone, %d changed)\n", deleted, len(entries), gone, changed)
+	return c.Statistics(), nil
+}
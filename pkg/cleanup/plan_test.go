@@ -0,0 +1,61 @@
+package cleanup
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestApplyPlanCountsFailedEvents(t *testing.T) {
+	cleaner, clientset, _ := newTestCleaner(&Config{},
+		newEvent("a", "e1", time.Minute), newEvent("a", "e2", time.Minute), newEvent("b", "e3", time.Minute))
+	clientset.PrependReactor("delete", "events", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() != "a" {
+			return false, nil, nil
+		}
+		return true, nil, errors.NewForbidden(schema.GroupResource{Resource: "events"}, "", nil)
+	})
+	entries := []PlanEntry{{Namespace: "a", Name: "e1"}, {Namespace: "a", Name: "e2"}, {Namespace: "b", Name: "e3"}}
+	stats, err := cleaner.ApplyPlan(context.Background(), entries)
+	if err != nil {
+		t.Fatalf("ApplyPlan: %s", err)
+	}
+	if stats.DeletedEvents != 1 || stats.FailedEvents != 2 {
+		t.Errorf("deleted, failed events = %d, %d, want 1, 2", stats.DeletedEvents, stats.FailedEvents)
+	}
+	if len(stats.Failures) != 1 || stats.Failures[0].Namespace != "a" {
+		t.Errorf("failures = %v, want one failure of namespace a", stats.Failures)
+	}
+}
+
+func TestApplyPlanLogsEachEvent(t *testing.T) {
+	for _, dryRun := range []bool{false, true} {
+		cleaner, _, out := newTestCleaner(&Config{DryRun: dryRun}, newEvent("a", "e1", time.Minute))
+		entries := []PlanEntry{{Namespace: "a", Name: "e1"}, {Namespace: "a", Name: "gone"}}
+		if _, err := cleaner.ApplyPlan(context.Background(), entries); err != nil {
+			t.Fatalf("ApplyPlan: %s", err)
+		}
+		want := []string{
+			"  Deleted event a/e1",
+			"  Skipping event a/gone, it is already gone",
+			"Deleted 1 of 2 planned events (1 already gone, 0 changed)",
+		}
+		if dryRun {
+			want = []string{
+				"  Would delete event a/e1",
+				"  Would delete event a/gone",
+				"Deleted 2 of 2 planned events (0 already gone, 0 changed)",
+			}
+		}
+		if got := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n"); !slices.Equal(got, want) {
+			t.Errorf("dry run %t: output = %q, want %q", dryRun, got, want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package cleanup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// permissionCheck is a single permission needed for the cleanup.
+type permissionCheck struct {
+	namespace string
+	verb      string
+	resource  string
+}
+
+func (c permissionCheck) String() string {
+	if c.resource == "namespaces" {
+		// cluster-scoped
+		return c.verb + " " + c.resource
+	}
+	scope := "all namespaces"
+	if c.namespace != "" {
+		scope = "namespace " + c.namespace
+	}
+	return fmt.Sprintf("%s %s in %s", c.verb, c.resource, scope)
+}
+
+// Preflight verifies with SelfSubjectAccessReviews that all permissions needed for the cleanup are granted.
+// The verbs checked depend on the mode: events are listed in every mode, deleted or patched unless nothing is
+// changed, and deleted or patched with server-side dry run if the permissions are verified in dry-run mode.
+// The lookups of involved objects for SkipIfObjectModifiedWithin are not checked, as their resources are unknown.
+func (c *Cleaner) Preflight(ctx context.Context) error {
+	cfg := c.cfg
+	var checks []permissionCheck
+	if len(cfg.Namespaces) != 1 || cfg.NamespaceLabelSelector != "" {
+		checks = append(checks, permissionCheck{verb: "list", resource: "namespaces"})
+	} else if cfg.RespectNamespaceAnnotations {
+		// the annotations of a single namespace are read with a get
+		checks = append(checks, permissionCheck{verb: "get", resource: "namespaces"})
+	}
+	eventNamespaces := cfg.Namespaces
+	if len(eventNamespaces) == 0 {
+		eventNamespaces = []string{""}
+	}
+	verb := "delete"
+	if cfg.MarkOnly {
+		verb = "patch"
+	}
+	for _, ns := range eventNamespaces {
+		checks = append(checks, permissionCheck{namespace: ns, verb: "list", resource: "events"})
+		if cfg.CountOnly || (cfg.DryRun && !cfg.VerifyPermissions) {
+			continue
+		}
+		checks = append(checks, permissionCheck{namespace: ns, verb: verb, resource: "events"})
+	}
+	return c.checkPermissions(ctx, checks)
+}
+
+// PreflightPlan verifies like Preflight that the events of a plan can be deleted.
+func (c *Cleaner) PreflightPlan(ctx context.Context, entries []PlanEntry) error {
+	var checks []permissionCheck
+	if !c.cfg.DryRun {
+		seen := map[string]bool{}
+		for _, entry := range entries {
+			if !seen[entry.Namespace] {
+				seen[entry.Namespace] = true
+				checks = append(checks, permissionCheck{namespace: entry.Namespace, verb: "delete", resource: "events"})
+			}
+		}
+	}
+	return c.checkPermissions(ctx, checks)
+}
+
+// checkPermissions runs the checks and returns an error listing the denied ones.
+func (c *Cleaner) checkPermissions(ctx context.Context, checks []permissionCheck) error {
+	c.logf("Preflight check of permissions:\n")
+	var denied []string
+	for _, check := range checks {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace: check.namespace,
+					Verb:      check.verb,
+					Resource:  check.resource,
+				},
+			},
+		}
+		result, err := c.clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("error checking permission to %s: %w", check, err)
+		}
+		if result.Status.Allowed {
+			c.logf("  allowed: %s\n", check)
+		} else {
+			c.logf("  denied:  %s\n", check)
+			denied = append(denied, check.String())
+		}
+	}
+	if len(denied) > 0 {
+		return fmt.Errorf("missing permissions to %s: please check the RBAC rules of the used service account or user", strings.Join(denied, ", "))
+	}
+	return nil
+}
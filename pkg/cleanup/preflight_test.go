@@ -0,0 +1,51 @@
+package cleanup
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestPreflightChecksVerbsOfMode(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want []string
+	}{
+		{"delete in all namespaces", Config{}, []string{"list namespaces", "list events in all namespaces", "delete events in all namespaces"}},
+		{"single namespace", Config{Namespaces: []string{"a"}}, []string{"list events in namespace a", "delete events in namespace a"}},
+		{"annotations of a single namespace", Config{Namespaces: []string{"a"}, RespectNamespaceAnnotations: true},
+			[]string{"get namespaces", "list events in namespace a", "delete events in namespace a"}},
+		{"label selector", Config{Namespaces: []string{"a"}, NamespaceLabelSelector: "team=x"},
+			[]string{"list namespaces", "list events in namespace a", "delete events in namespace a"}},
+		{"mark-only", Config{Namespaces: []string{"a"}, MarkOnly: true}, []string{"list events in namespace a", "patch events in namespace a"}},
+		{"dry run", Config{Namespaces: []string{"a"}, DryRun: true}, []string{"list events in namespace a"}},
+		{"dry run verifying permissions", Config{Namespaces: []string{"a"}, DryRun: true, VerifyPermissions: true},
+			[]string{"list events in namespace a", "delete events in namespace a"}},
+		{"count-only", Config{Namespaces: []string{"a"}, CountOnly: true}, []string{"list events in namespace a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cleaner, clientset, _ := newTestCleaner(&cfg)
+			var checked []string
+			clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+				attrs := review.Spec.ResourceAttributes
+				checked = append(checked, permissionCheck{namespace: attrs.Namespace, verb: attrs.Verb, resource: attrs.Resource}.String())
+				review.Status.Allowed = true
+				return true, review, nil
+			})
+			if err := cleaner.Preflight(context.Background()); err != nil {
+				t.Fatalf("Preflight: %s", err)
+			}
+			if !slices.Equal(checked, tt.want) {
+				t.Errorf("checked permissions = %q, want %q", checked, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package cleanup
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// probePageSize is the number of events checked per namespace by Probe.
+const probePageSize = 100
+
+// Probe checks cheaply if there are expired events, without a full scan. Only the first page of
+// events of each selected namespace and field selector is checked, and it stops at the first expired
+// event. As the apiserver returns events ordered by name and not by age, expired events beyond the
+// first page are missed, so a negative result is a hint only. The events are selected like in
+// CleanNamespace, except for the filters applied after a full scan of the namespace, like
+// protect-recent-per-reason and skip-if-object-modified-within.
+func (c *Cleaner) Probe(ctx context.Context) (bool, error) {
+	namespaces, err := c.selectNamespaces(ctx)
+	if err != nil {
+		return false, err
+	}
+	selectors := c.cfg.FieldSelectors
+	if len(selectors) == 0 {
+		selectors = []string{""}
+	}
+	for _, ns := range namespaces {
+		for _, selector := range selectors {
+			events, err := c.clientset.CoreV1().Events(ns).List(ctx, metav1.ListOptions{FieldSelector: selector, Limit: probePageSize})
+			if err != nil {
+				return false, fmt.Errorf("error listing events in namespace %s: %w", ns, err)
+			}
+			// like in CleanNamespace, the events are judged at the time they were received
+			selectEvent := c.eventSelector(ns, c.clock.Now())
+			for i := range events.Items {
+				event := &events.Items[i]
+				if selected, _, _ := selectEvent(event); selected {
+					c.logf("Found expired event %s in namespace %s\n", event.Name, ns)
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
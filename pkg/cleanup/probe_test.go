@@ -0,0 +1,67 @@
+package cleanup
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestProbeAppliesFilters(t *testing.T) {
+	withReason := func(reason string) func(*corev1.Event) {
+		return func(event *corev1.Event) { event.Reason = reason }
+	}
+	events := []*corev1.Event{
+		newEvent("a", "old-backoff", 2*time.Hour, withReason("BackOff")),
+		newEvent("a", "recent-pulled", 10*time.Minute, withReason("Pulled")),
+	}
+	tests := []struct {
+		name string
+		cfg  Config
+		want bool
+	}{
+		{"expired event", Config{}, true},
+		{"expired event excluded by reason", Config{ExcludeReasons: map[string]bool{"BackOff": true}}, false},
+		{"expired event not included by reason", Config{IncludeReasons: map[string]bool{"Pulled": true}}, false},
+		{"longer retention of the reason", Config{Retention: map[string]time.Duration{"BackOff": 3 * time.Hour}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.Namespaces = []string{"a"}
+			cleaner, _, _ := newTestCleaner(&cfg, events[0], events[1])
+			found, err := cleaner.Probe(context.Background())
+			if err != nil {
+				t.Fatalf("Probe: %s", err)
+			}
+			if found != tt.want {
+				t.Errorf("Probe = %t, want %t", found, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeListsEachFieldSelector(t *testing.T) {
+	selectors := []string{"type=Warning", "reason=BackOff"}
+	cleaner, clientset, _ := newTestCleaner(&Config{Namespaces: []string{"a"}, FieldSelectors: selectors},
+		newEvent("a", "recent", 10*time.Minute))
+	found, err := cleaner.Probe(context.Background())
+	if err != nil {
+		t.Fatalf("Probe: %s", err)
+	}
+	if found {
+		t.Errorf("Probe found an expired event")
+	}
+	var listed []string
+	for _, action := range clientset.Actions() {
+		if list, ok := action.(k8stesting.ListAction); ok && action.GetResource().Resource == "events" {
+			listed = append(listed, list.GetListRestrictions().Fields.String())
+		}
+	}
+	if !slices.Equal(listed, selectors) {
+		t.Errorf("listed field selectors = %v, want %v", listed, selectors)
+	}
+}
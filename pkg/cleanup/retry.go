@@ -0,0 +1,120 @@
+package cleanup
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// DefaultRetryHTTPStatus are the HTTP status codes of API errors which are retried by default.
+var DefaultRetryHTTPStatus = []int{429, 500, 502, 503, 504}
+
+// RetryBudget limits the total number of retries over the whole run.
+// It is safe for concurrent use.
+type RetryBudget struct {
+	// Limit is the maximum number of retries. If 0, the retries are unlimited.
+	Limit int64
+	used  atomic.Int64
+
+	mu      sync.Mutex
+	byCause map[string]int64
+}
+
+// take consumes one retry from the budget. It returns false if the budget is exhausted.
+func (b *RetryBudget) take() bool {
+	if b == nil {
+		return true
+	}
+	if b.used.Add(1) > b.Limit && b.Limit > 0 {
+		b.used.Add(-1)
+		return false
+	}
+	return true
+}
+
+// record counts a retry by the cause of the error.
+func (b *RetryBudget) record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.byCause == nil {
+		b.byCause = map[string]int64{}
+	}
+	b.byCause[retryCause(err)]++
+}
+
+// UsedByCause returns the number of retries consumed so far by cause.
+func (b *RetryBudget) UsedByCause() map[string]int64 {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return maps.Clone(b.byCause)
+}
+
+// retryCause classifies the error of a retried operation.
+func retryCause(err error) string {
+	switch {
+	case apierrors.IsTimeout(err), apierrors.IsServerTimeout(err), errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case apierrors.IsTooManyRequests(err):
+		return "throttled"
+	case apierrors.IsConflict(err):
+		return "conflict"
+	case apierrors.IsInternalError(err), apierrors.IsServiceUnavailable(err), apierrors.IsUnexpectedServerError(err):
+		return "server error"
+	}
+	var status apierrors.APIStatus
+	if errors.As(err, &status) && status.Status().Code >= 500 {
+		return "server error"
+	}
+	if errors.As(err, &status) {
+		return "other"
+	}
+	return "network"
+}
+
+// Used returns the number of retries consumed so far.
+func (b *RetryBudget) Used() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.used.Load()
+}
+
+// retryable returns true if the error should be retried. Errors without an API status, like network
+// errors, are always retried. API errors are only retried if their HTTP status code is in the list.
+// If the list is nil, all errors are retried.
+func retryable(err error, statuses []int) bool {
+	var status apierrors.APIStatus
+	if statuses == nil || !errors.As(err, &status) {
+		return true
+	}
+	return slices.Contains(statuses, int(status.Status().Code))
+}
+
+// withRetries calls op until it succeeds, fails with a non-retryable error or the retries are used up.
+// The backoff between the calls is aborted if the context is cancelled.
+func (c *Cleaner) withRetries(ctx context.Context, op func() error) error {
+	for i := 0; ; i++ {
+		err := op()
+		if err == nil || i >= c.cfg.Retries || !retryable(err, c.cfg.RetryHTTPStatus) || !c.retryBudget.take() {
+			return err
+		}
+		c.retryBudget.record(err)
+		backoff := time.Duration(i+1) * 50 * time.Millisecond
+		if err := sleep(ctx, c.clock, backoff); err != nil {
+			return err
+		}
+		c.stats.AddBackoff(backoff)
+	}
+}
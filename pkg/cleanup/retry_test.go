@@ -0,0 +1,66 @@
+package cleanup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// stalledClock never ends a wait. It signals each wait started on waiting.
+type stalledClock struct {
+	waiting chan time.Duration
+}
+
+func (c *stalledClock) Now() time.Time {
+	return time.Now()
+}
+
+func (c *stalledClock) After(d time.Duration) <-chan time.Time {
+	c.waiting <- d
+	return nil
+}
+
+func TestWithRetriesCancelledDuringBackoff(t *testing.T) {
+	tests := []struct {
+		name   string
+		cancel func(ctx context.Context) (context.Context, context.CancelFunc)
+		want   error
+	}{
+		{"canceled", context.WithCancel, context.Canceled},
+		{"deadline exceeded", func(ctx context.Context) (context.Context, context.CancelFunc) {
+			return context.WithDeadline(ctx, time.Now())
+		}, context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &stalledClock{waiting: make(chan time.Duration, 1)}
+			cleaner, _, _ := newTestCleaner(&Config{Retries: 5, Clock: clock})
+			ctx, cancel := tt.cancel(context.Background())
+			defer cancel()
+
+			calls := 0
+			result := make(chan error, 1)
+			go func() {
+				result <- cleaner.withRetries(ctx, func() error {
+					calls++
+					return fmt.Errorf("connection refused")
+				})
+			}()
+			<-clock.waiting
+			cancel()
+			select {
+			case err := <-result:
+				if !errors.Is(err, tt.want) {
+					t.Errorf("error = %v, want %v", err, tt.want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("withRetries did not return after the context was done")
+			}
+			if calls != 1 {
+				t.Errorf("calls = %d, want 1", calls)
+			}
+		})
+	}
+}
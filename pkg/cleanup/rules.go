@@ -0,0 +1,108 @@
+package cleanup
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// retainRule is a filter which retains events regardless of their age.
+// Its name is reported by the explain mode, so it refers to the option enabling it.
+type retainRule struct {
+	name   string
+	retain func(event *corev1.Event) bool
+}
+
+// retainRules returns the enabled filters which retain events regardless of their age, in the order they are checked.
+// Missing fields of an event, e.g. of an empty involved object, never match a filter: an event lacking the field of an
+// include filter is retained, and an exclude filter does not retain it.
+func (c *Cleaner) retainRules(now time.Time) []retainRule {
+	cfg := c.cfg
+	rules := []retainRule{
+		// the age cannot be trusted if the clocks are skewed
+		{"future-dated", func(event *corev1.Event) bool { return isFutureDated(event, now) }},
+	}
+	if !cfg.IncludeSelf {
+		rules = append(rules, retainRule{"own event (include-self)", isOwnEvent})
+	}
+	if len(cfg.IncludeReasons) > 0 {
+		rules = append(rules, retainRule{"include-reasons", func(event *corev1.Event) bool { return !cfg.IncludeReasons[event.Reason] }})
+	}
+	if len(cfg.ExcludeReasons) > 0 {
+		rules = append(rules, retainRule{"exclude-reasons", func(event *corev1.Event) bool { return cfg.ExcludeReasons[event.Reason] }})
+	}
+	if cfg.RequireMatchingInvolvedNamespace {
+		rules = append(rules, retainRule{"require-matching-involved-namespace", func(event *corev1.Event) bool {
+			return event.InvolvedObject.Namespace != event.Namespace
+		}})
+	}
+	if cfg.InvolvedNameRegex != nil {
+		rules = append(rules, retainRule{"involved-name-regex", func(event *corev1.Event) bool {
+			return event.InvolvedObject.Name == "" || !cfg.InvolvedNameRegex.MatchString(event.InvolvedObject.Name)
+		}})
+	}
+	if cfg.ExcludeInvolvedNameRegex != nil {
+		rules = append(rules, retainRule{"exclude-involved-name-regex", func(event *corev1.Event) bool {
+			return event.InvolvedObject.Name != "" && cfg.ExcludeInvolvedNameRegex.MatchString(event.InvolvedObject.Name)
+		}})
+	}
+	if cfg.SourceComponentRegex != nil {
+		rules = append(rules, retainRule{"source-component-regex", func(event *corev1.Event) bool {
+			return !cfg.SourceComponentRegex.MatchString(sourceComponent(event))
+		}})
+	}
+	if cfg.ExcludeSourceComponentRegex != nil {
+		rules = append(rules, retainRule{"exclude-source-component-regex", func(event *corev1.Event) bool {
+			return cfg.ExcludeSourceComponentRegex.MatchString(sourceComponent(event))
+		}})
+	}
+	if cfg.MaxCountToDelete > 0 {
+		// frequently recurring events are likely important
+		rules = append(rules, retainRule{"max-count-to-delete", func(event *corev1.Event) bool { return event.Count > cfg.MaxCountToDelete }})
+	}
+	if cfg.MinSeriesGap > 0 {
+		rules = append(rules, retainRule{"min-series-gap", func(event *corev1.Event) bool { return isActiveSeries(event, now, cfg.MinSeriesGap) }})
+	}
+	return rules
+}
+
+// retainedBy returns the name of the first rule retaining the event, or an empty string if no rule does.
+func retainedBy(rules []retainRule, event *corev1.Event) string {
+	for _, rule := range rules {
+		if rule.retain(event) {
+			return rule.name
+		}
+	}
+	return ""
+}
+
+// explanation is an explained decision about an event, which is logged later.
+type explanation struct {
+	name     string
+	selected bool
+	rule     string
+}
+
+// explain logs the rule deciding about an event, until the limit of explained events is reached.
+func (c *Cleaner) explain(namespace, name string, selected bool, rule string) {
+	decision := "Retained"
+	if selected {
+		decision = "Selected"
+	}
+	c.explainDecision(namespace, name, decision, rule)
+}
+
+// explainDecision logs a decision about an event with the rule causing it.
+func (c *Cleaner) explainDecision(namespace, name, decision, rule string) {
+	if c.cfg.Explain == 0 {
+		return
+	}
+	switch n := c.explained.Add(1); {
+	case n == int64(c.cfg.Explain)+1:
+		c.logf("  Explained %d events, further events are not explained\n", c.cfg.Explain)
+		return
+	case n > int64(c.cfg.Explain):
+		return
+	}
+	c.logf("  %s event %s/%s: %s\n", decision, namespace, name, rule)
+}
@@ -0,0 +1,292 @@
+package cleanup
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Statistics collects the counters of a cleanup run.
+// All updates must be done with its methods, which are safe for concurrent use.
+// The fields may be read directly once the run has finished.
+type Statistics struct {
+	mu sync.Mutex
+
+	TotalEvents       int
+	DeletedEvents     int
+	MarkedEvents      int
+	NamespacesScanned int
+	// SampledFrom is the number of namespaces a random sample was drawn from. It is 0 if all namespaces were scanned.
+	SampledFrom int
+	// FlaggedNamespaces are the namespaces exceeding the event count warning threshold.
+	FlaggedNamespaces []string
+	// PerNamespace holds the event counts of each scanned namespace.
+	PerNamespace map[string]*NamespaceStatistics
+	// PerKind holds the event counts by kind of the involved object. Events without involved kind are counted as "<none>".
+	PerKind map[string]*KindStatistics
+	// ExpiredByReason counts the expired events by reason, only filled in count-only mode if requested.
+	ExpiredByReason map[string]int
+	// BlockedNamespaces are the namespaces skipped as an admission webhook denied modifying their events.
+	BlockedNamespaces []string
+	// TruncatedNamespaces are the namespaces not scanned as the run has been stopped after MaxTotalEvents.
+	TruncatedNamespaces []string
+	// TimedOutNamespaces are the namespaces abandoned as their cleanup exceeded the namespace timeout.
+	TimedOutNamespaces []string
+	// FailedEvents is the number of events which could not be deleted or marked.
+	FailedEvents int
+	// Failures are the namespaces which could not be cleaned up.
+	Failures []*NamespaceError
+	// Retries is the number of retries of API calls.
+	Retries int64
+	// Backoff is the time spent waiting between retries, summed up over concurrent deletes.
+	Backoff time.Duration
+	// RetriesByCause is the number of retries by the classified cause of the error.
+	RetriesByCause map[string]int64
+	// SelectedBytes is the estimated serialized size of the selected events, only filled if requested.
+	SelectedBytes int64
+	// OldestDeleted and OldestRetained are the effective timestamps of the oldest selected and
+	// the oldest retained event. They are zero if there was no such event.
+	OldestDeleted  time.Time
+	OldestRetained time.Time
+	// FutureDated counts the events created more than a minute after they were listed. They are never deleted.
+	FutureDated int
+	// WhatIf counts the events which would be expired for each of the what-if durations of the config.
+	WhatIf []int
+	// DeletedAges is the histogram of the effective age of the deleted events.
+	DeletedAges AgeHistogram
+}
+
+// NamespaceStatistics holds the event counts of a single namespace.
+type NamespaceStatistics struct {
+	TotalEvents   int
+	DeletedEvents int
+}
+
+// KindStatistics holds the event counts of a kind of involved objects.
+type KindStatistics struct {
+	TotalEvents   int
+	DeletedEvents int
+}
+
+func (s *Statistics) AddTotal(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.TotalEvents += n
+}
+
+// totalEvents returns the number of events scanned so far.
+func (s *Statistics) totalEvents() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.TotalEvents
+}
+
+func (s *Statistics) addTruncated(namespace string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.TruncatedNamespaces = append(s.TruncatedNamespaces, namespace)
+}
+
+func (s *Statistics) AddDeleted(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.DeletedEvents += n
+}
+
+func (s *Statistics) AddMarked(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.MarkedEvents += n
+}
+
+func (s *Statistics) AddSelectedBytes(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.SelectedBytes += n
+}
+
+func (s *Statistics) AddOldest(deleted, retained time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.OldestDeleted = earliest(s.OldestDeleted, deleted)
+	s.OldestRetained = earliest(s.OldestRetained, retained)
+}
+
+func (s *Statistics) AddWhatIf(counts []int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.WhatIf == nil {
+		s.WhatIf = make([]int, len(counts))
+	}
+	for i, n := range counts {
+		s.WhatIf[i] += n
+	}
+}
+
+func (s *Statistics) AddFutureDated(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.FutureDated += n
+}
+
+func (s *Statistics) IncNamespacesScanned() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.NamespacesScanned++
+}
+
+func (s *Statistics) AddNamespace(namespace string, total, deleted int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.PerNamespace == nil {
+		s.PerNamespace = map[string]*NamespaceStatistics{}
+	}
+	nsStats := s.PerNamespace[namespace]
+	if nsStats == nil {
+		nsStats = &NamespaceStatistics{}
+		s.PerNamespace[namespace] = nsStats
+	}
+	nsStats.TotalEvents += total
+	nsStats.DeletedEvents += deleted
+}
+
+func (s *Statistics) AddKindTotals(counts map[string]int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for kind, n := range counts {
+		s.kindStatistics(kind).TotalEvents += n
+	}
+}
+
+func (s *Statistics) AddKindDeleted(kind string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.kindStatistics(kind).DeletedEvents += n
+}
+
+// kindStatistics returns the counts of the kind, which are created if needed. The lock must be held.
+func (s *Statistics) kindStatistics(kind string) *KindStatistics {
+	if kind == "" {
+		kind = "<none>"
+	}
+	if s.PerKind == nil {
+		s.PerKind = map[string]*KindStatistics{}
+	}
+	kindStats := s.PerKind[kind]
+	if kindStats == nil {
+		kindStats = &KindStatistics{}
+		s.PerKind[kind] = kindStats
+	}
+	return kindStats
+}
+
+func (s *Statistics) setSampled(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.SampledFrom = n
+}
+
+func (s *Statistics) AddFailedEvents(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.FailedEvents += n
+}
+
+func (s *Statistics) AddTimedOutNamespace(namespace string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.TimedOutNamespaces = append(s.TimedOutNamespaces, namespace)
+}
+
+func (s *Statistics) AddBlockedNamespace(namespace string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.BlockedNamespaces = append(s.BlockedNamespaces, namespace)
+}
+
+func (s *Statistics) AddFlaggedNamespace(namespace string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.FlaggedNamespaces = append(s.FlaggedNamespaces, namespace)
+}
+
+func (s *Statistics) AddExpiredByReason(counts map[string]int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ExpiredByReason == nil {
+		s.ExpiredByReason = map[string]int{}
+	}
+	for reason, n := range counts {
+		s.ExpiredByReason[reason] += n
+	}
+}
+
+func (s *Statistics) AddFailure(err *NamespaceError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Failures = append(s.Failures, err)
+}
+
+func (s *Statistics) AddBackoff(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Backoff += d
+}
+
+func (s *Statistics) setRetries(n int64, byCause map[string]int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Retries = n
+	s.RetriesByCause = byCause
+}
+
+// AgeHistogramBuckets are the upper bounds of the buckets of the age histogram of deleted events.
+var AgeHistogramBuckets = []time.Duration{
+	time.Minute, 5 * time.Minute, 15 * time.Minute, 30 * time.Minute,
+	time.Hour, 2 * time.Hour, 6 * time.Hour, 12 * time.Hour,
+	24 * time.Hour, 2 * 24 * time.Hour, 7 * 24 * time.Hour, 14 * 24 * time.Hour, 30 * 24 * time.Hour,
+}
+
+// AgeHistogram counts durations in the buckets given by AgeHistogramBuckets.
+// The last count is for durations above the last bucket.
+type AgeHistogram struct {
+	Counts []int
+	Total  int
+	Sum    time.Duration
+	Max    time.Duration
+}
+
+func (h *AgeHistogram) observe(age time.Duration) {
+	if h.Counts == nil {
+		h.Counts = make([]int, len(AgeHistogramBuckets)+1)
+	}
+	i := sort.Search(len(AgeHistogramBuckets), func(i int) bool { return age <= AgeHistogramBuckets[i] })
+	h.Counts[i]++
+	h.Total++
+	h.Sum += age
+	h.Max = max(h.Max, age)
+}
+
+// Quantile returns the upper bound of the bucket containing the given quantile (0 < q <= 1).
+// If the maximum observed age is lower or the quantile is above the last bound, the maximum is returned.
+func (h *AgeHistogram) Quantile(q float64) time.Duration {
+	if h.Total == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(q * float64(h.Total)))
+	sum := 0
+	for i, n := range h.Counts {
+		sum += n
+		if sum >= rank && i < len(AgeHistogramBuckets) {
+			return min(AgeHistogramBuckets[i], h.Max.Round(time.Second))
+		}
+	}
+	return h.Max.Round(time.Second)
+}
+
+func (s *Statistics) AddDeletedAge(age time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.DeletedAges.observe(age)
+}
@@ -0,0 +1,55 @@
+package cleanup
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestStatisticsConcurrentUpdates is meant to be run with -race.
+func TestStatisticsConcurrentUpdates(t *testing.T) {
+	const workers, updates = 8, 100
+	stats := &Statistics{}
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			namespace := []string{"a", "b"}[w%2]
+			for range updates {
+				stats.AddTotal(2)
+				stats.AddDeleted(1)
+				stats.IncNamespacesScanned()
+				stats.AddNamespace(namespace, 2, 1)
+				stats.AddKindTotals(map[string]int{"Pod": 2})
+				stats.AddKindDeleted("Pod", 1)
+				stats.AddExpiredByReason(map[string]int{"Pulled": 1})
+				stats.AddDeletedAge(time.Hour)
+				stats.AddBackoff(time.Millisecond)
+				_ = stats.totalEvents()
+			}
+		}()
+	}
+	wg.Wait()
+
+	const n = workers * updates
+	tests := []struct {
+		name      string
+		got, want int
+	}{
+		{"TotalEvents", stats.TotalEvents, 2 * n},
+		{"DeletedEvents", stats.DeletedEvents, n},
+		{"NamespacesScanned", stats.NamespacesScanned, n},
+		{"PerNamespace[a].TotalEvents", stats.PerNamespace["a"].TotalEvents, n},
+		{"PerNamespace[b].DeletedEvents", stats.PerNamespace["b"].DeletedEvents, n / 2},
+		{"PerKind[Pod].TotalEvents", stats.PerKind["Pod"].TotalEvents, 2 * n},
+		{"PerKind[Pod].DeletedEvents", stats.PerKind["Pod"].DeletedEvents, n},
+		{"ExpiredByReason[Pulled]", stats.ExpiredByReason["Pulled"], n},
+		{"Backoff (ms)", int(stats.Backoff / time.Millisecond), n},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.name, tt.got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package cleanup
+
+import (
+	"bytes"
+	"text/template"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// TemplateData is passed to the dry-run template for each selected event.
+type TemplateData struct {
+	Event *corev1.Event
+	// Age is the age of the event according to the age basis.
+	Age time.Duration
+}
+
+// ParseTemplateFile parses the dry-run template from a file.
+func ParseTemplateFile(path string) (*template.Template, error) {
+	return template.ParseFiles(path)
+}
+
+// writeTemplate renders the dry-run template for a selected event to the progress log.
+func (c *Cleaner) writeTemplate(cand candidate, now time.Time) {
+	data := TemplateData{Event: cand.event, Age: now.Sub(cand.timestamp).Round(time.Second)}
+	// the output is rendered first, so that it is written at once and not interleaved with other output
+	var buf bytes.Buffer
+	if err := c.cfg.DryRunTemplate.Execute(&buf, data); err != nil {
+		c.logf("  error executing template for event %s/%s: %s\n", cand.event.Namespace, cand.name, err)
+		return
+	}
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	_, _ = c.out.Write(buf.Bytes())
+}
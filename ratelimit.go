@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimitedTransport lets every request to the apiserver, including retries, pass a shared rate limiter.
+// If adaptive is set, the rate is adjusted to the throttling responses of the apiserver.
+type rateLimitedTransport struct {
+	limiter  *rate.Limiter
+	adaptive *adaptiveRate
+	next     http.RoundTripper
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	if t.adaptive != nil && err == nil {
+		t.adaptive.observe(t.limiter, resp.StatusCode == http.StatusTooManyRequests, time.Now())
+	}
+	return resp, err
+}
+
+// adaptiveRate adjusts the rate with additive increase and multiplicative decrease (AIMD).
+// A throttled response halves the rate, at most once per window, as the requests in flight at the
+// time are usually throttled together. After each window without throttling, the rate is increased
+// by a tenth of the maximum rate until the maximum is reached again.
+type adaptiveRate struct {
+	max    rate.Limit
+	min    rate.Limit
+	window time.Duration
+
+	mu           sync.Mutex
+	lastStep     time.Time
+	lastDecrease time.Time
+	current      rate.Limit
+	lowest       rate.Limit
+	decreases    int
+	increases    int
+}
+
+func newAdaptiveRate(maxRate rate.Limit, window time.Duration) *adaptiveRate {
+	return &adaptiveRate{
+		max:      maxRate,
+		min:      min(1, maxRate),
+		window:   window,
+		lastStep: time.Now(),
+		current:  maxRate,
+		lowest:   maxRate,
+	}
+}
+
+func (a *adaptiveRate) observe(limiter *rate.Limiter, throttled bool, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch {
+	case throttled:
+		// no increase while the apiserver is still throttling
+		a.lastStep = now
+		if a.current == a.min || (!a.lastDecrease.IsZero() && now.Sub(a.lastDecrease) < a.window) {
+			return
+		}
+		a.current = max(a.current/2, a.min)
+		a.lowest = min(a.lowest, a.current)
+		a.lastDecrease = now
+		a.decreases++
+		fmt.Printf("Throttled by the apiserver, reducing the QPS to %.1f\n", float64(a.current))
+	case a.current < a.max && now.Sub(a.lastStep) >= a.window:
+		a.current = min(a.current+a.max/10, a.max)
+		a.lastStep = now
+		a.increases++
+		fmt.Printf("Raising the QPS to %.1f\n", float64(a.current))
+	default:
+		return
+	}
+	limiter.SetLimit(a.current)
+}
+
+// changes returns the rate at the end and the lowest rate, together with the number of decreases and increases.
+func (a *adaptiveRate) changes() (current, lowest rate.Limit, decreases, increases int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.current, a.lowest, a.decreases, a.increases
+}
+
+func (a *adaptiveRate) String() string {
+	current, lowest, decreases, increases := a.changes()
+	return fmt.Sprintf("final=%.1f min=%.1f decreases=%d increases=%d", float64(current), float64(lowest), decreases, increases)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRateLimitedTransportSpacing(t *testing.T) {
+	const qps, workers, callsPerWorker = 50, 4, 5
+	interval := time.Second / qps
+
+	var mu sync.Mutex
+	var calls []time.Time
+	transport := &rateLimitedTransport{
+		limiter: rate.NewLimiter(qps, 1),
+		next: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			mu.Lock()
+			calls = append(calls, time.Now())
+			mu.Unlock()
+			return httptest.NewRecorder().Result(), nil
+		}),
+	}
+
+	// concurrent workers like the namespace workers, sharing the transport
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range callsPerWorker {
+				req := httptest.NewRequest(http.MethodDelete, "/api/v1/namespaces/a/events/e", nil)
+				if _, err := transport.RoundTrip(req); err != nil {
+					t.Errorf("RoundTrip: %s", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	slices.SortFunc(calls, func(a, b time.Time) int { return a.Compare(b) })
+	// the first call uses the burst, all further calls are spaced by the interval on average
+	if span, want := calls[len(calls)-1].Sub(calls[0]), time.Duration(len(calls)-1)*interval*9/10; span < want {
+		t.Errorf("%d calls took %s, want at least %s", len(calls), span, want)
+	}
+}
+
+func TestAdaptiveRateSteps(t *testing.T) {
+	const window = time.Minute
+	start := time.Now()
+	adaptive := newAdaptiveRate(100, window)
+	limiter := rate.NewLimiter(100, 1)
+
+	steps := []struct {
+		name      string
+		after     time.Duration
+		throttled bool
+		want      rate.Limit
+	}{
+		{"throttled", time.Second, true, 50},
+		{"throttled again within the window", 2 * time.Second, true, 50},
+		{"not throttled within the window", 30 * time.Second, false, 50},
+		{"throttled after the window", 70 * time.Second, true, 25},
+		{"window without throttling", 131 * time.Second, false, 35},
+		{"next window without throttling", 192 * time.Second, false, 45},
+		{"within the window after the increase", 200 * time.Second, false, 45},
+	}
+	for _, step := range steps {
+		adaptive.observe(limiter, step.throttled, start.Add(step.after))
+		if got := limiter.Limit(); got != step.want {
+			t.Fatalf("%s: limit = %.1f, want %.1f", step.name, float64(got), float64(step.want))
+		}
+	}
+	current, lowest, decreases, increases := adaptive.changes()
+	if current != 45 || lowest != 25 || decreases != 2 || increases != 2 {
+		t.Errorf("changes = %.1f, %.1f, %d, %d, want 45, 25, 2, 2", float64(current), float64(lowest), decreases, increases)
+	}
+
+	// the rate is never raised above the maximum and never reduced below the minimum
+	adaptive = newAdaptiveRate(1, window)
+	limiter = rate.NewLimiter(1, 1)
+	adaptive.observe(limiter, true, start.Add(time.Second))
+	adaptive.observe(limiter, false, start.Add(2*window))
+	if got := limiter.Limit(); got != 1 {
+		t.Errorf("limit = %.1f, want 1", float64(got))
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/MartinWeindel/kubectl-filter-output/pkg/cleanup"
+)
+
+// printSummary prints the statistics and failures of the finished run.
+func printSummary(cfg *cleanup.Config, stats *cleanup.Statistics, opts *Options, calls *apiCalls, adaptive *adaptiveRate, start time.Time) {
+	failures := stats.Failures
+	mode := "Deleted"
+	msg := "Cleanup completed"
+	affected := stats.DeletedEvents
+	switch {
+	case cfg.CountOnly:
+		mode = "Expired"
+		msg = "Counting completed"
+	case cfg.DryRun && cfg.MarkOnly:
+		mode = "To be marked"
+		msg = "Dry run completed"
+		affected = stats.MarkedEvents
+	case cfg.DryRun:
+		mode = "To be deleted"
+		msg = "Dry run completed"
+	case cfg.MarkOnly:
+		mode = "Marked"
+		affected = stats.MarkedEvents
+	}
+	if len(failures) == 0 {
+		fmt.Printf("%s successfully.\n", msg)
+	} else {
+		fmt.Printf("%s with errors in %d namespaces.\n", msg, len(failures))
+	}
+
+	retries := fmt.Sprintf("%d", stats.Retries)
+	if cfg.RetryBudget > 0 {
+		retries = fmt.Sprintf("%d (budget: %d)", stats.Retries, cfg.RetryBudget)
+	}
+	metrics := [][2]string{
+		{"Namespaces scanned", fmt.Sprintf("%d", stats.NamespacesScanned)},
+		{"Total events", fmt.Sprintf("%d", stats.TotalEvents)},
+		{mode + " events", fmt.Sprintf("%d", affected)},
+		{"Retained events", fmt.Sprintf("%d", stats.TotalEvents-stats.DeletedEvents)},
+		{"Retries", retries},
+		{"API calls", calls.String()},
+		{"API time", calls.Timings()},
+		{"Retry backoff", stats.Backoff.Round(time.Millisecond).String()},
+		{"Runtime", time.Since(start).Round(time.Millisecond).String()},
+		{"Heap (peak, approx.)", formatBytes(int64(peakHeap()))},
+	}
+	if adaptive != nil {
+		metrics = append(metrics, [2]string{"QPS (adaptive)", adaptive.String()})
+	}
+	if stats.FailedEvents > 0 {
+		metrics = append(metrics, [2]string{"Failed events", fmt.Sprintf("%d", stats.FailedEvents)})
+	}
+	if stats.FutureDated > 0 {
+		metrics = append(metrics, [2]string{"Future-dated events", fmt.Sprintf("%d", stats.FutureDated)})
+	}
+	now := time.Now()
+	if !stats.OldestDeleted.IsZero() {
+		metrics = append(metrics, [2]string{"Oldest " + strings.ToLower(mode) + " event", formatOldest(stats.OldestDeleted, now)})
+	}
+	if !stats.OldestRetained.IsZero() {
+		metrics = append(metrics, [2]string{"Oldest retained event", formatOldest(stats.OldestRetained, now)})
+	}
+	if cfg.EstimateSize {
+		metrics = append(metrics, [2]string{mode + " size (approx.)", formatBytes(stats.SelectedBytes)})
+	}
+	if opts.AgeQuantiles && stats.DeletedAges.Total > 0 {
+		ages := &stats.DeletedAges
+		metrics = append(metrics,
+			[2]string{mode + " event age p50", "<= " + ages.Quantile(0.5).String()},
+			[2]string{mode + " event age p90", "<= " + ages.Quantile(0.9).String()},
+			[2]string{mode + " event age p99", "<= " + ages.Quantile(0.99).String()},
+			[2]string{mode + " event age max", ages.Max.Round(time.Second).String()},
+		)
+	}
+	fmt.Printf("Statistics:\n")
+	if opts.NoTable {
+		for _, m := range metrics {
+			fmt.Printf("  %s: %s\n", m[0], m[1])
+		}
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, m := range metrics {
+			fmt.Fprintf(w, "  %s\t%s\n", m[0], m[1])
+		}
+		w.Flush()
+		printNamespaceTable(stats, mode)
+		printKindTable(stats, mode)
+	}
+
+	if stats.SampledFrom > 0 && stats.NamespacesScanned > 0 {
+		factor := float64(stats.SampledFrom) / float64(stats.NamespacesScanned)
+		fmt.Printf("Projection for all %d namespaces from a random sample of %d (estimate only, accurate only if events are spread evenly):\n",
+			stats.SampledFrom, stats.NamespacesScanned)
+		fmt.Printf("  Total events: ~%.0f\n", float64(stats.TotalEvents)*factor)
+		fmt.Printf("  %s events: ~%.0f\n", mode, float64(affected)*factor)
+	}
+	if stats.FutureDated > opts.FutureDatedWarn {
+		fmt.Printf("WARNING: %d events have a creation timestamp in the future. Check the clocks of this host and the cluster for skew, the ages of all events may be wrong.\n", stats.FutureDated)
+	}
+	if len(stats.WhatIf) > 0 {
+		printWhatIf(cfg, stats, opts)
+	}
+	if len(stats.RetriesByCause) > 0 {
+		fmt.Printf("Retries by cause:\n")
+		causes := make([]string, 0, len(stats.RetriesByCause))
+		for cause := range stats.RetriesByCause {
+			causes = append(causes, cause)
+		}
+		sort.Strings(causes)
+		for _, cause := range causes {
+			fmt.Printf("  %s: %d\n", cause, stats.RetriesByCause[cause])
+		}
+	}
+	if len(stats.TruncatedNamespaces) > 0 {
+		fmt.Printf("Run truncated by max-total-events %d, namespaces not scanned: %s\n", cfg.MaxTotalEvents, strings.Join(stats.TruncatedNamespaces, ", "))
+	}
+	if len(stats.TimedOutNamespaces) > 0 {
+		fmt.Printf("Namespaces timed out: %s\n", strings.Join(stats.TimedOutNamespaces, ", "))
+	}
+	if len(stats.BlockedNamespaces) > 0 {
+		fmt.Printf("Namespaces blocked by admission webhooks: %s\n", strings.Join(stats.BlockedNamespaces, ", "))
+	}
+	if len(stats.FlaggedNamespaces) > 0 {
+		fmt.Printf("Namespaces with more than %d events: %s\n", cfg.WarnEventCount, strings.Join(stats.FlaggedNamespaces, ", "))
+	}
+	if len(stats.ExpiredByReason) > 0 {
+		fmt.Printf("Expired events by reason:\n")
+		reasons := make([]string, 0, len(stats.ExpiredByReason))
+		for reason := range stats.ExpiredByReason {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		for _, reason := range reasons {
+			fmt.Printf("  %s: %d\n", reason, stats.ExpiredByReason[reason])
+		}
+	}
+	if len(failures) > 0 {
+		fmt.Printf("Failed namespaces:\n")
+		byKind := map[string][]string{}
+		for _, f := range failures {
+			byKind[f.Kind()] = append(byKind[f.Kind()], f.Namespace)
+		}
+		kinds := make([]string, 0, len(byKind))
+		for kind := range byKind {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		for _, kind := range kinds {
+			fmt.Printf("  %s: %s\n", kind, strings.Join(byKind[kind], ", "))
+		}
+	}
+}
+
+// printWhatIf prints the number of events expired for each what-if duration.
+func printWhatIf(cfg *cleanup.Config, stats *cleanup.Statistics, opts *Options) {
+	fmt.Printf("What-if:\n")
+	if opts.NoTable {
+		for i, d := range cfg.WhatIf {
+			fmt.Printf("  %s: %d\n", d, stats.WhatIf[i])
+		}
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "  DURATION\tEXPIRED\tRETAINED\n")
+	for i, d := range cfg.WhatIf {
+		fmt.Fprintf(w, "  %s\t%d\t%d\n", d, stats.WhatIf[i], stats.TotalEvents-stats.WhatIf[i])
+	}
+	w.Flush()
+}
+
+// printNamespaceTable prints the namespaces with events sorted by the number of deleted events.
+func printNamespaceTable(stats *cleanup.Statistics, mode string) {
+	var namespaces []string
+	for ns, nsStats := range stats.PerNamespace {
+		if nsStats.TotalEvents > 0 {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	if len(namespaces) == 0 {
+		return
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		a, b := stats.PerNamespace[namespaces[i]], stats.PerNamespace[namespaces[j]]
+		if a.DeletedEvents != b.DeletedEvents {
+			return a.DeletedEvents > b.DeletedEvents
+		}
+		return namespaces[i] < namespaces[j]
+	})
+
+	fmt.Printf("Namespaces:\n")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "  NAMESPACE\tTOTAL\t%s\tRETAINED\n", strings.ToUpper(mode))
+	for _, ns := range namespaces {
+		nsStats := stats.PerNamespace[ns]
+		fmt.Fprintf(w, "  %s\t%d\t%d\t%d\n", ns, nsStats.TotalEvents, nsStats.DeletedEvents, nsStats.TotalEvents-nsStats.DeletedEvents)
+	}
+	w.Flush()
+}
+
+// maxKinds is the number of kinds printed in the kind table.
+const maxKinds = 10
+
+// printKindTable prints the kinds of involved objects with the most deleted events.
+func printKindTable(stats *cleanup.Statistics, mode string) {
+	if len(stats.PerKind) == 0 {
+		return
+	}
+	kinds := make([]string, 0, len(stats.PerKind))
+	for kind := range stats.PerKind {
+		kinds = append(kinds, kind)
+	}
+	sort.Slice(kinds, func(i, j int) bool {
+		a, b := stats.PerKind[kinds[i]], stats.PerKind[kinds[j]]
+		if a.DeletedEvents != b.DeletedEvents {
+			return a.DeletedEvents > b.DeletedEvents
+		}
+		if a.TotalEvents != b.TotalEvents {
+			return a.TotalEvents > b.TotalEvents
+		}
+		return kinds[i] < kinds[j]
+	})
+
+	if len(kinds) > maxKinds {
+		fmt.Printf("Involved object kinds (top %d of %d):\n", maxKinds, len(kinds))
+		kinds = kinds[:maxKinds]
+	} else {
+		fmt.Printf("Involved object kinds:\n")
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "  KIND\tTOTAL\t%s\n", strings.ToUpper(mode))
+	for _, kind := range kinds {
+		kindStats := stats.PerKind[kind]
+		fmt.Fprintf(w, "  %s\t%d\t%d\n", kind, kindStats.TotalEvents, kindStats.DeletedEvents)
+	}
+	w.Flush()
+}
+
+// formatOldest formats the timestamp of an oldest event together with its age.
+func formatOldest(t, now time.Time) string {
+	return fmt.Sprintf("%s (age %s)", t.UTC().Format(time.RFC3339), now.Sub(t).Round(time.Second))
+}
+
+// peakHeap returns the address space reserved for the heap. It never shrinks, so it is
+// an upper bound close to the peak heap size.
+func peakHeap() uint64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapSys
+}
+
+// formatBytes formats a byte count with a binary unit.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,139 @@
+// Package integration_test runs the cleanup against a real apiserver. It is a module of its own, so that
+// envtest and its dependencies are not part of the dependency graph of cleanup-events.
+package integration_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+
+	"github.com/MartinWeindel/kubectl-filter-output/pkg/cleanup"
+)
+
+// TestRunAgainstAPIServer runs the cleanup against a real apiserver started by envtest, which evaluates field
+// selectors and paginates like a cluster. It is skipped without the binaries of envtest, which are installed with
+//
+//	export KUBEBUILDER_ASSETS=$(go run sigs.k8s.io/controller-runtime/tools/setup-envtest@latest use -p path)
+func TestRunAgainstAPIServer(t *testing.T) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set to the envtest binaries")
+	}
+	env := &envtest.Environment{}
+	restConfig, err := env.Start()
+	if err != nil {
+		t.Fatalf("starting envtest: %s", err)
+	}
+	defer func() {
+		if err := env.Stop(); err != nil {
+			t.Errorf("stopping envtest: %s", err)
+		}
+	}()
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cfg  cleanup.Config
+		want []string
+	}{
+		{
+			name: "expired events",
+			want: []string{"recent-backoff", "recent-normal"},
+		},
+		{
+			name: "paginated",
+			cfg:  cleanup.Config{PageSize: 2},
+			want: []string{"recent-backoff", "recent-normal"},
+		},
+		{
+			name: "field selectors",
+			cfg:  cleanup.Config{FieldSelectors: []string{"reason=BackOff", "type=Warning"}},
+			want: []string{"old-normal", "recent-backoff", "recent-normal"},
+		},
+		{
+			name: "dry run",
+			cfg:  cleanup.Config{DryRun: true},
+			want: []string{"old-backoff", "old-normal", "old-warning", "recent-backoff", "recent-normal"},
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			namespace := "test-" + string(rune('a'+i))
+			if _, err := clientset.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}}, metav1.CreateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			seedEvents(t, clientset, namespace)
+
+			cfg := tt.cfg
+			cfg.Namespaces = []string{namespace}
+			cfg.Duration = time.Hour
+			// the apiserver sets the creation timestamp, so the age is taken from the last timestamp
+			cfg.AgeBasis = cleanup.AgeBasisLast
+			cfg.Out = io.Discard
+			if err := cfg.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			stats, err := cleanup.NewCleaner(clientset, &cfg).Run(ctx)
+			if err != nil {
+				t.Fatalf("Run: %s", err)
+			}
+			if len(stats.Failures) > 0 {
+				t.Fatalf("failures: %v", stats.Failures)
+			}
+
+			list, err := clientset.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, event := range list.Items {
+				got = append(got, event.Name)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("remaining events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// seedEvents creates old and recent events of different reasons and types in the namespace.
+func seedEvents(t *testing.T, clientset kubernetes.Interface, namespace string) {
+	t.Helper()
+	seeds := []struct {
+		name, reason, eventType string
+		age                     time.Duration
+	}{
+		{"old-backoff", "BackOff", corev1.EventTypeWarning, 3 * time.Hour},
+		{"old-warning", "FailedMount", corev1.EventTypeWarning, 2 * time.Hour},
+		{"old-normal", "Pulled", corev1.EventTypeNormal, 5 * time.Hour},
+		{"recent-backoff", "BackOff", corev1.EventTypeWarning, 10 * time.Minute},
+		{"recent-normal", "Pulled", corev1.EventTypeNormal, time.Minute},
+	}
+	for _, seed := range seeds {
+		last := metav1.NewTime(time.Now().Add(-seed.age))
+		event := &corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Namespace: namespace, Name: seed.name},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Namespace: namespace, Name: "pod"},
+			Reason:         seed.reason,
+			Type:           seed.eventType,
+			FirstTimestamp: last,
+			LastTimestamp:  last,
+			Count:          1,
+		}
+		if _, err := clientset.CoreV1().Events(namespace).Create(context.Background(), event, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("creating event %s: %s", seed.name, err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/MartinWeindel/kubectl-filter-output/pkg/cleanup"
+)
+
+// writeTextfile writes the statistics of the run in the Prometheus text format for the textfile collector
+// of the node exporter. The file is replaced atomically, so that the collector never reads a partial file.
+// The collector does not accept sample timestamps, so the end of the run is exported as a gauge instead.
+// Ages and timestamps are exported only if there are events they refer to.
+func writeTextfile(path string, cfg *cleanup.Config, stats *cleanup.Statistics, calls *apiCalls, adaptive *adaptiveRate, start time.Time) error {
+	mode := "deleted"
+	affected := stats.DeletedEvents
+	switch {
+	case cfg.CountOnly:
+		mode = "expired"
+	case cfg.MarkOnly:
+		mode = "marked"
+		affected = stats.MarkedEvents
+	}
+	dryRun := 0
+	if cfg.DryRun {
+		dryRun = 1
+	}
+
+	var b strings.Builder
+	gauge := func(name, help string, value any, labels string) {
+		fmt.Fprintf(&b, "# HELP cleanup_events_%s %s\n", name, help)
+		fmt.Fprintf(&b, "# TYPE cleanup_events_%s gauge\n", name)
+		fmt.Fprintf(&b, "cleanup_events_%s%s %v\n", name, labels, value)
+	}
+	gauge("namespaces_scanned", "Number of namespaces scanned in the last run.", stats.NamespacesScanned, "")
+	gauge("events", "Number of events scanned in the last run.", stats.TotalEvents, "")
+	gauge("selected_events", "Number of events deleted, marked or counted as expired in the last run.", affected,
+		fmt.Sprintf(`{mode=%q,dry_run="%d"}`, mode, dryRun))
+	gauge("failed_events", "Number of events which could not be deleted or marked in the last run.", stats.FailedEvents, "")
+	gauge("failed_namespaces", "Number of namespaces which could not be cleaned up in the last run.", len(stats.Failures), "")
+	gauge("truncated_namespaces", "Number of namespaces not scanned in the last run due to max-total-events.", len(stats.TruncatedNamespaces), "")
+	gauge("retries", "Number of retried API calls in the last run.", stats.Retries, "")
+	if adaptive != nil {
+		current, lowest, decreases, increases := adaptive.changes()
+		gauge("qps", "Rate limit of the API calls at the end of the last run, adapted to throttling.", float64(current), "")
+		gauge("qps_min", "Lowest rate limit of the API calls in the last run, adapted to throttling.", float64(lowest), "")
+		gauge("qps_decreases", "Number of times the rate limit was reduced due to throttling in the last run.", decreases, "")
+		gauge("qps_increases", "Number of times the rate limit was raised again in the last run.", increases, "")
+	}
+	now := time.Now()
+	if !stats.OldestDeleted.IsZero() {
+		gauge("oldest_selected_age_seconds", "Age of the oldest event deleted, marked or counted as expired in the last run.",
+			now.Sub(stats.OldestDeleted).Seconds(), "")
+	}
+	if !stats.OldestRetained.IsZero() {
+		gauge("oldest_retained_age_seconds", "Age of the oldest retained event in the last run.", now.Sub(stats.OldestRetained).Seconds(), "")
+	}
+	if ages := &stats.DeletedAges; ages.Total > 0 {
+		const name = "cleanup_events_deleted_age_seconds"
+		fmt.Fprintf(&b, "# HELP %s Effective age of the events deleted (or to be deleted in a dry run) in the last run.\n", name)
+		fmt.Fprintf(&b, "# TYPE %s histogram\n", name)
+		cumulative := 0
+		for i, bound := range cleanup.AgeHistogramBuckets {
+			cumulative += ages.Counts[i]
+			fmt.Fprintf(&b, "%s_bucket{le=\"%v\"} %d\n", name, bound.Seconds(), cumulative)
+		}
+		fmt.Fprintf(&b, "%s_bucket{le=\"+Inf\"} %d\n", name, ages.Total)
+		fmt.Fprintf(&b, "%s_sum %v\n", name, ages.Sum.Seconds())
+		fmt.Fprintf(&b, "%s_count %d\n", name, ages.Total)
+	}
+	fmt.Fprintf(&b, "# HELP cleanup_events_api_calls Number of API calls by verb in the last run, including retries.\n")
+	fmt.Fprintf(&b, "# TYPE cleanup_events_api_calls gauge\n")
+	for _, verb := range []struct {
+		name  string
+		count *atomic.Int64
+	}{
+		{"list", &calls.list}, {"get", &calls.get}, {"create", &calls.create},
+		{"patch", &calls.patch}, {"delete", &calls.delete}, {"other", &calls.other},
+	} {
+		fmt.Fprintf(&b, "cleanup_events_api_calls{verb=%q} %d\n", verb.name, verb.count.Load())
+	}
+	gauge("heap_peak_bytes", "Approximate peak heap size of the last run.", peakHeap(), "")
+	gauge("run_duration_seconds", "Duration of the last run.", time.Since(start).Seconds(), "")
+	gauge("last_run_timestamp_seconds", "Unix time of the end of the last run.", now.Unix(), "")
+
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating textfile: %w", err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString(b.String()); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing textfile: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error writing textfile: %w", err)
+	}
+	// CreateTemp creates the file readable by the owner only
+	if err := os.Chmod(file.Name(), 0o644); err != nil {
+		return fmt.Errorf("error writing textfile: %w", err)
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("error replacing textfile: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MartinWeindel/kubectl-filter-output/pkg/cleanup"
+)
+
+func TestWriteTextfile(t *testing.T) {
+	stats := &cleanup.Statistics{}
+	stats.AddTotal(3)
+	stats.AddDeleted(2)
+	stats.AddDeletedAge(90 * time.Second)
+	stats.AddDeletedAge(3 * time.Hour)
+	calls := &apiCalls{}
+	calls.list.Add(2)
+	calls.delete.Add(2)
+	path := filepath.Join(t.TempDir(), "cleanup.prom")
+	if err := writeTextfile(path, &cleanup.Config{}, stats, calls, nil, time.Now()); err != nil {
+		t.Fatalf("writeTextfile: %s", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(content), "\n")
+	for _, want := range []string{
+		`cleanup_events_selected_events{mode="deleted",dry_run="0"} 2`,
+		`cleanup_events_deleted_age_seconds_bucket{le="60"} 0`,
+		`cleanup_events_deleted_age_seconds_bucket{le="300"} 1`,
+		`cleanup_events_deleted_age_seconds_bucket{le="21600"} 2`,
+		`cleanup_events_deleted_age_seconds_bucket{le="+Inf"} 2`,
+		`cleanup_events_deleted_age_seconds_sum 10890`,
+		`cleanup_events_deleted_age_seconds_count 2`,
+		`cleanup_events_api_calls{verb="list"} 2`,
+		`cleanup_events_api_calls{verb="delete"} 2`,
+	} {
+		if !slices.Contains(lines, want) {
+			t.Errorf("missing line %s in:\n%s", want, content)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// timeWindow is a daily time range. If end is before start, the window spans midnight.
+type timeWindow struct {
+	start, end time.Duration
+	location   *time.Location
+}
+
+// parseTimeWindow parses a window of the form HH:MM-HH:MM in the given location.
+func parseTimeWindow(value string, location *time.Location) (*timeWindow, error) {
+	from, to, ok := strings.Cut(value, "-")
+	if !ok {
+		return nil, fmt.Errorf("expected HH:MM-HH:MM: %s", value)
+	}
+	start, err := parseTimeOfDay(from)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseTimeOfDay(to)
+	if err != nil {
+		return nil, err
+	}
+	if start == end {
+		return nil, fmt.Errorf("start and end must differ: %s", value)
+	}
+	return &timeWindow{start: start, end: end, location: location}, nil
+}
+
+// parseTimeOfDay parses HH:MM as the duration since midnight.
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// contains returns true if the time of day of t is within the window.
+func (w *timeWindow) contains(t time.Time) bool {
+	t = t.In(w.location)
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if w.start < w.end {
+		return offset >= w.start && offset < w.end
+	}
+	return offset >= w.start || offset < w.end
+}
+
+// endAfter returns the end of the window containing t, which must be within the window.
+func (w *timeWindow) endAfter(t time.Time) time.Time {
+	t = t.In(w.location)
+	year, month, day := t.Date()
+	end := time.Date(year, month, day, 0, 0, 0, 0, w.location).Add(w.end)
+	if !end.After(t) {
+		// the window spans midnight and t is before midnight
+		end = time.Date(year, month, day+1, 0, 0, 0, 0, w.location).Add(w.end)
+	}
+	return end
+}
+
+func (w *timeWindow) String() string {
+	format := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return fmt.Sprintf("%s-%s %s", format(w.start), format(w.end), w.location)
+}
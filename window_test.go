@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeWindowEndAfter(t *testing.T) {
+	at := func(hour, minute int) time.Time { return time.Date(2024, 3, 1, hour, minute, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name   string
+		window string
+		now    time.Time
+		want   time.Time
+	}{
+		{"same day", "08:00-17:00", at(9, 30), at(17, 0)},
+		{"spanning midnight before midnight", "22:00-05:00", at(23, 0), at(29, 0)},
+		{"spanning midnight after midnight", "22:00-05:00", at(1, 0), at(5, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			window, err := parseTimeWindow(tt.window, time.UTC)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !window.contains(tt.now) {
+				t.Fatalf("window %s does not contain %s", window, tt.now)
+			}
+			if got := window.endAfter(tt.now); !got.Equal(tt.want) {
+				t.Errorf("endAfter(%s) = %s, want %s", tt.now, got, tt.want)
+			}
+		})
+	}
+}